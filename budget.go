@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// budgetThresholds are the percentages of a budget that raise an alert
+var budgetThresholds = []int{50, 80, 100}
+
+// budgetWarningThreshold is the percentage of a budget from which the
+// presence shows budgets.warning_icon
+const budgetWarningThreshold = 80
+
+// budgetHookTimeout bounds how long a budget hook may run
+const budgetHookTimeout = 30 * time.Second
+
+// budgetAlert is a budget reaching one of the thresholds
+type budgetAlert struct {
+	// Period is daily or monthly
+	Period string `json:"period"`
+	// Project is empty for the global budget
+	Project   string  `json:"project,omitempty"`
+	Threshold int     `json:"threshold"`
+	Spent     float64 `json:"spent"`
+	Limit     float64 `json:"limit"`
+}
+
+func (a budgetAlert) title() string {
+	if a.Threshold >= 100 {
+		return "Claude Code budget used up"
+	}
+	return fmt.Sprintf("Claude Code budget %d%% used", a.Threshold)
+}
+
+func (a budgetAlert) message() string {
+	msg := fmt.Sprintf("%s of the %s %s budget", formatCost(a.Spent, 2), formatCost(a.Limit, 2), a.Period)
+	if a.Project != "" {
+		msg += " for " + a.Project
+	}
+	return msg
+}
+
+// budgetTracker remembers which thresholds were alerted in the current day
+// and month, so each is only alerted once. It is used under daemonMu.
+type budgetTracker struct {
+	// alerted is the highest threshold alerted per period and budget
+	alerted map[string]int
+	// warning is the most used budget at or above budgetWarningThreshold
+	warning *budgetAlert
+}
+
+var budgets = &budgetTracker{}
+
+// check compares the totals with the budgets and returns an alert for every
+// budget that reached a new threshold. Only the highest threshold reached is
+// alerted, so a daemon that starts late in the day does not send three.
+func (b *budgetTracker) check(c BudgetConfig, t *usageTotalsTracker, now time.Time) []budgetAlert {
+	if b.alerted == nil {
+		b.alerted = map[string]int{}
+	}
+	day, month := periodKey(now, "day"), periodKey(now, "month")
+
+	var alerts []budgetAlert
+	b.warning = nil
+	consider := func(period, key, project string, spent, limit float64) {
+		if limit <= 0 {
+			return
+		}
+		reached := 0
+		for _, threshold := range budgetThresholds {
+			if spent >= limit*float64(threshold)/100 {
+				reached = threshold
+			}
+		}
+		if reached == 0 {
+			return
+		}
+
+		alert := budgetAlert{Period: period, Project: project, Threshold: reached, Spent: spent, Limit: limit}
+		if reached >= budgetWarningThreshold && (b.warning == nil || spent/limit > b.warning.Spent/b.warning.Limit) {
+			b.warning = &alert
+		}
+		key += "/" + project
+		if b.alerted[key] < reached {
+			b.alerted[key] = reached
+			alerts = append(alerts, alert)
+		}
+	}
+
+	consider("daily", day, "", t.Today.Cost, c.Daily)
+	consider("monthly", month, "", t.Month.Cost, c.Monthly)
+
+	names := make([]string, 0, len(c.Projects))
+	for name := range c.Projects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spent := t.Projects[name]
+		if spent == nil {
+			spent = &periodTotals{}
+		}
+		consider("daily", day, name, spent.Today.Cost, c.Projects[name].Daily)
+		consider("monthly", month, name, spent.Month.Cost, c.Projects[name].Monthly)
+	}
+
+	// Forget the days and months that are over
+	for key := range b.alerted {
+		if !strings.HasPrefix(key, day+"/") && !strings.HasPrefix(key, month+"/") {
+			delete(b.alerted, key)
+		}
+	}
+	return alerts
+}
+
+// checkBudgets sends the alerts for budget thresholds reached since the last
+// check. Callers must hold daemonMu.
+func checkBudgets(now time.Time) {
+	for _, alert := range budgets.check(cfg.Budgets, totals, now) {
+		go sendBudgetAlert(cfg.Budgets, alert)
+	}
+}
+
+// sendBudgetAlert notifies the user and runs the budget hook
+func sendBudgetAlert(c BudgetConfig, alert budgetAlert) {
+	slog.Info("Budget threshold reached", "period", alert.Period, "project", alert.Project,
+		"threshold", alert.Threshold, "spent", alert.Spent, "limit", alert.Limit)
+
+	if err := newNotifier(c.Notifier).Notify(alert.title(), alert.message()); err != nil {
+		slog.Error("Failed to send budget notification", "err", err)
+	}
+	if c.Hook != "" {
+		if err := runBudgetHook(c.Hook, alert); err != nil {
+			slog.Error("Budget hook failed", "hook", c.Hook, "err", err)
+		}
+	}
+}
+
+// runBudgetHook runs the hook command with the alert as JSON on stdin and in
+// CC_BUDGET_* environment variables
+func runBudgetHook(command string, alert budgetAlert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	cmd := shellCommand(command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(),
+		"CC_BUDGET_PERIOD="+alert.Period,
+		"CC_BUDGET_PROJECT="+alert.Project,
+		fmt.Sprintf("CC_BUDGET_THRESHOLD=%d", alert.Threshold),
+		fmt.Sprintf("CC_BUDGET_SPENT=%.2f", alert.Spent),
+		fmt.Sprintf("CC_BUDGET_LIMIT=%.2f", alert.Limit),
+	)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(budgetHookTimeout, func() { cmd.Process.Kill() })
+	defer timer.Stop()
+	return cmd.Wait()
+}
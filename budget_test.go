@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBudgetTrackerCheck tests which thresholds raise alerts
+func TestBudgetTrackerCheck(t *testing.T) {
+	c := BudgetConfig{
+		BudgetLimits: BudgetLimits{Daily: 10, Monthly: 100},
+		Projects:     map[string]BudgetLimits{"app": {Daily: 2}, "idle": {Daily: 1}},
+	}
+	spend := func(today, month, app float64) *usageTotalsTracker {
+		return &usageTotalsTracker{
+			periodTotals: periodTotals{Today: usageTotals{Cost: today}, Month: usageTotals{Cost: month}},
+			Projects:     map[string]*periodTotals{"app": {Today: usageTotals{Cost: app}}},
+		}
+	}
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name        string
+		totals      *usageTotalsTracker
+		now         time.Time
+		wantAlerts  []string
+		wantWarning string
+	}{
+		{
+			name:   "under every threshold",
+			totals: spend(4, 40, 0.5),
+			now:    now,
+		},
+		{
+			name:       "half the daily budget",
+			totals:     spend(5, 40, 0.5),
+			now:        now,
+			wantAlerts: []string{"daily//50"},
+		},
+		{
+			name:       "same threshold is not alerted again",
+			totals:     spend(6, 40, 0.5),
+			now:        now,
+			wantAlerts: nil,
+		},
+		{
+			name:        "jumping past two thresholds alerts the highest",
+			totals:      spend(11, 85, 0.5),
+			now:         now,
+			wantAlerts:  []string{"daily//100", "monthly//80"},
+			wantWarning: "$11.00 of the $10.00 daily budget",
+		},
+		{
+			name:        "project budget",
+			totals:      spend(11, 85, 1.6),
+			now:         now,
+			wantAlerts:  []string{"daily/app/80"},
+			wantWarning: "$11.00 of the $10.00 daily budget",
+		},
+		{
+			name:        "a new day alerts daily budgets again",
+			totals:      spend(5, 85, 1.9),
+			now:         now.AddDate(0, 0, 1),
+			wantAlerts:  []string{"daily//50", "daily/app/80"},
+			wantWarning: "$1.90 of the $2.00 daily budget for app",
+		},
+	}
+
+	// The cases run in order against the same tracker
+	b := &budgetTracker{}
+	for _, tt := range tests {
+		alerts := b.check(c, tt.totals, tt.now)
+		var got []string
+		for _, a := range alerts {
+			got = append(got, fmt.Sprintf("%s/%s/%d", a.Period, a.Project, a.Threshold))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.wantAlerts, " ") {
+			t.Errorf("%s: alerts = %v, want %v", tt.name, got, tt.wantAlerts)
+		}
+
+		gotWarning := ""
+		if b.warning != nil {
+			gotWarning = b.warning.message()
+		}
+		if gotWarning != tt.wantWarning {
+			t.Errorf("%s: warning = %q, want %q", tt.name, gotWarning, tt.wantWarning)
+		}
+	}
+}
+
+// TestRunBudgetHook tests the alert passed to the hook command
+func TestRunBudgetHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+
+	out := filepath.Join(t.TempDir(), "hook.out")
+	alert := budgetAlert{Period: "daily", Project: "app", Threshold: 80, Spent: 8.5, Limit: 10}
+	hook := `echo "$CC_BUDGET_PERIOD $CC_BUDGET_PROJECT $CC_BUDGET_THRESHOLD $CC_BUDGET_SPENT $CC_BUDGET_LIMIT" > ` + out + ` && cat >> ` + out
+	if err := runBudgetHook(hook, alert); err != nil {
+		t.Fatalf("runBudgetHook() error: %v", err)
+	}
+
+	got, _ := os.ReadFile(out)
+	want := "daily app 80 8.50 10.00\n" +
+		`{"period":"daily","project":"app","threshold":80,"spent":8.5,"limit":10}`
+	if string(got) != want {
+		t.Errorf("hook got %q, want %q", got, want)
+	}
+
+	if err := runBudgetHook("exit 1", alert); err == nil {
+		t.Error("Expected error for a failing hook")
+	}
+}
+
+type testNotifier struct {
+	err  error
+	sent *[]string
+}
+
+func (n testNotifier) Notify(title, message string) error {
+	if n.err != nil {
+		return n.err
+	}
+	*n.sent = append(*n.sent, title+": "+message)
+	return nil
+}
+
+// TestFallbackNotifier tests falling back to the next notifier on failure
+func TestFallbackNotifier(t *testing.T) {
+	var first, second []string
+	n := fallbackNotifier{
+		testNotifier{err: errors.New("no session bus"), sent: &first},
+		testNotifier{sent: &second},
+	}
+	if err := n.Notify("title", "message"); err != nil {
+		t.Fatalf("Notify() error: %v", err)
+	}
+	if len(first) != 0 || len(second) != 1 || second[0] != "title: message" {
+		t.Errorf("sent first = %v, second = %v, want only second", first, second)
+	}
+
+	if err := (fallbackNotifier{testNotifier{err: errors.New("down"), sent: &first}}).Notify("t", "m"); err == nil {
+		t.Error("Expected error when every notifier fails")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// command is a CLI subcommand
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	// Assigned in init to break the initialization cycle through printUsage
+	commands = []command{
+		{"run", "Run the presence daemon in the foreground (default)", cmdRun},
+		{"status", "Show whether a daemon is running and what it is showing", cmdStatus},
+		{"print", "Print the current presence once, for status bars and prompts", cmdPrint},
+		{"watch", "Print the presence every time it changes", cmdWatch},
+		{"stop", "Stop the running daemon", cmdStop},
+		{"pause", "Hide the presence from Discord without stopping the daemon", cmdPause},
+		{"resume", "Show the presence again after pause", cmdResume},
+		{"attach", "Register a Claude Code session, starting the daemon if needed", cmdAttach},
+		{"detach", "Unregister a session, stopping the daemon after the last one", cmdDetach},
+		{"doctor", "Diagnose setup problems", cmdDoctor},
+		{"report", "Summarize token usage and cost from all transcripts", cmdReport},
+		{"setup", "Point Claude Code's statusLine at this binary", cmdSetup},
+		{"uninstall", "Restore the statusLine that setup replaced", cmdUninstall},
+		{"statusline", "Save Claude Code statusline data (used as the statusLine command)", cmdStatusLine},
+		{"version", "Print the version", cmdVersion},
+	}
+}
+
+// runCLI dispatches to a subcommand and returns the process exit code
+func runCLI(args []string) int {
+	// No subcommand (or only flags) keeps the old behaviour of running the daemon
+	if len(args) == 0 || (len(args[0]) > 0 && args[0][0] == '-' && !isHelpFlag(args[0])) {
+		return cmdRun(args)
+	}
+
+	name := args[0]
+	if name == "help" || isHelpFlag(name) {
+		printUsage(os.Stdout)
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(args[1:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	printUsage(os.Stderr)
+	return 2
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: cc-discord-presence <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-11s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'cc-discord-presence <command> -h' for command flags.")
+}
+
+// newFlagSet creates a flag set for a subcommand with the common flags registered
+func newFlagSet(name string, common *commonFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	common.register(fs)
+	return fs
+}
+
+// parseCommand parses flags and loads the config. If ok is false the command
+// should return code without doing anything else.
+func parseCommand(fs *flag.FlagSet, common *commonFlags, args []string) (code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0, false
+		}
+		return 2, false
+	}
+	if _, err := common.load(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to load config: %v\n", err)
+		return 1, false
+	}
+	return 0, true
+}
+
+func cmdRun(args []string) int {
+	fs := newFlagSet("run", &daemonFlags)
+	daemonFlags.registerLog(fs)
+	if code, ok := parseCommand(fs, &daemonFlags, args); !ok {
+		return code
+	}
+	return runDaemon()
+}
+
+func cmdStatus(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("status", &common)
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	pid := readPIDFile()
+	if !processExists(pid) {
+		fmt.Println("⏹ Discord Rich Presence is not running")
+		return 3
+	}
+
+	fmt.Printf("▶ Discord Rich Presence is running (PID: %d)\n", pid)
+
+	// Ask the daemon first for live state, older daemons only write the file
+	var state DaemonState
+	if err := callControl(http.MethodGet, "/status", nil, &state); err != nil {
+		fromFile, err := readDaemonState()
+		if err != nil || fromFile.PID != pid {
+			fmt.Println("   No state reported yet")
+			return 0
+		}
+		state = *fromFile
+	}
+
+	fmt.Printf("   Version:     %s\n", state.Version)
+	fmt.Printf("   Started:     %s (%s ago)\n", state.StartedAt.Format(time.DateTime), time.Since(state.StartedAt).Round(time.Second))
+	if state.DiscordUser != "" {
+		fmt.Printf("   Discord:     %s (%s)\n", state.DiscordUser, state.DiscordBuild)
+	}
+	switch {
+	case state.Paused:
+		fmt.Println("   Presence:    paused")
+	case state.Quiet:
+		fmt.Println("   Presence:    hidden during quiet hours")
+	}
+	if state.Override.active() {
+		fmt.Printf("   Override:    %s\n", formatOverride(state.Override))
+	}
+	if state.LastUpdate.IsZero() {
+		fmt.Println("   Session:     waiting for Claude Code session")
+		return 0
+	}
+
+	project := state.Project
+	if state.GitBranch != "" {
+		project = fmt.Sprintf("%s (%s)", state.Project, state.GitBranch)
+	}
+	fmt.Printf("   Session:     %s\n", project)
+	fmt.Printf("   Model:       %s | %s tokens | $%.4f\n", state.Model, formatNumber(state.TotalTokens), state.TotalCost)
+	fmt.Printf("   Data source: %s\n", state.DataSource)
+	fmt.Printf("   Last update: %s (%s ago)\n", state.LastUpdate.Format(time.DateTime), time.Since(state.LastUpdate).Round(time.Second))
+	return 0
+}
+
+// formatOverride describes an override for status output
+func formatOverride(o *presenceOverride) string {
+	var parts []string
+	if o.Details != "" {
+		parts = append(parts, fmt.Sprintf("details %q", o.Details))
+	}
+	if o.State != "" {
+		parts = append(parts, fmt.Sprintf("state %q", o.State))
+	}
+	text := strings.Join(parts, ", ")
+	if !o.Until.IsZero() {
+		text += fmt.Sprintf(" until %s", o.Until.Format(time.TimeOnly))
+	}
+	return text
+}
+
+func cmdStop(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("stop", &common)
+	timeout := fs.Duration("timeout", 5*time.Second, "how long to wait for the daemon to exit")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	pid, err := stopDaemon(*timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to stop daemon: %v\n", err)
+		return 1
+	}
+	if pid == 0 {
+		fmt.Println("Discord Rich Presence is not running")
+		return 0
+	}
+	fmt.Printf("Discord Rich Presence stopped (PID: %d)\n", pid)
+	return 0
+}
+
+func cmdVersion(args []string) int {
+	fmt.Printf("cc-discord-presence %s\n", buildVersion())
+	return 0
+}
+
+// buildVersion returns the ldflags version, falling back to the module
+// version when installed with `go install`
+func buildVersion() string {
+	if version != "dev" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return version
+}
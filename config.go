@@ -0,0 +1,464 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// configFileName is the default config file inside the Claude directory
+const configFileName = "discord-presence-config.json"
+
+// Config holds the user-configurable settings. Zero values are filled in from
+// defaultConfig, so a config file only needs the keys it wants to change.
+type Config struct {
+	ClientID     string           `json:"client_id"`
+	ClaudeDir    string           `json:"claude_dir"`
+	PollInterval Duration         `json:"poll_interval"`
+	StatusLine   StatusLineConfig `json:"statusline"`
+	Presence     PresenceConfig   `json:"presence"`
+	Sinks        SinksConfig      `json:"sinks"`
+	// QuietHours are schedules during which presence is hidden
+	QuietHours []Schedule    `json:"quiet_hours"`
+	Budgets    BudgetConfig  `json:"budgets"`
+	Metrics    MetricsConfig `json:"metrics"`
+	Log        LogConfig     `json:"log"`
+}
+
+// MetricsConfig controls the Prometheus metrics endpoint
+type MetricsConfig struct {
+	// Listen is the address to serve /metrics on, such as "127.0.0.1:9464".
+	// Without a host only localhost is listened on; empty disables it.
+	Listen string `json:"listen"`
+}
+
+// addr returns the address to listen on
+func (m MetricsConfig) addr() string {
+	return localAddr(m.Listen)
+}
+
+// localAddr fills in localhost for a listen address without a host, so
+// nothing is reachable from other machines unless asked for
+func localAddr(listen string) string {
+	host, port, _ := net.SplitHostPort(listen)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// BudgetLimits are spend limits in dollars; zero means no limit
+type BudgetLimits struct {
+	Daily   float64 `json:"daily"`
+	Monthly float64 `json:"monthly"`
+}
+
+// BudgetConfig sets spend budgets and how to alert when they run out
+type BudgetConfig struct {
+	// The global limits cover all projects
+	BudgetLimits
+	// Projects has limits per project name
+	Projects map[string]BudgetLimits `json:"projects"`
+	// Notifier is desktop, which falls back to the log, or log
+	Notifier string `json:"notifier"`
+	// WarningIcon is a Discord image shown on the presence while a budget is
+	// nearly used up; empty shows none
+	WarningIcon string `json:"warning_icon"`
+	// Hook is a command run for every alert
+	Hook string `json:"hook"`
+}
+
+// LogConfig controls the daemon's log output
+type LogConfig struct {
+	// Level is debug, info, warn or error
+	Level string `json:"level"`
+	// Format is text or json
+	Format string `json:"format"`
+	// File is rotated once it reaches MaxSizeMB, keeping MaxFiles old logs.
+	// Empty logs to stderr.
+	File      string `json:"file"`
+	MaxSizeMB int    `json:"max_size_mb"`
+	MaxFiles  int    `json:"max_files"`
+}
+
+// SinksConfig selects the outputs the presence is shown on
+type SinksConfig struct {
+	Discord DiscordSinkConfig `json:"discord"`
+	Slack   SlackSinkConfig   `json:"slack"`
+	// Webhooks each POST the presence to a URL
+	Webhooks []WebhookSinkConfig `json:"webhooks"`
+	Overlay  OverlaySinkConfig   `json:"overlay"`
+	DBus     DBusSinkConfig      `json:"dbus"`
+}
+
+// OverlaySinkConfig serves a live overlay page for OBS browser sources
+type OverlaySinkConfig struct {
+	Enabled bool `json:"enabled"`
+	// Listen is the address to serve on; without a host only localhost is
+	// listened on
+	Listen string `json:"listen"`
+}
+
+// DBusSinkConfig publishes the session on the D-Bus session bus, Linux only
+type DBusSinkConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DiscordSinkConfig controls Discord Rich Presence, using client_id
+type DiscordSinkConfig struct {
+	Enabled bool `json:"enabled"`
+	// IPCPath is the Discord socket (or pipe on Windows) to connect to;
+	// empty searches the usual places
+	IPCPath string `json:"ipc_path"`
+	// Prefer picks a Discord when several are running: "stable", "canary",
+	// "ptb" or part of the socket path, like "vesktop"
+	Prefer string `json:"prefer"`
+}
+
+// SlackSinkConfig sets the Slack status of the user a token belongs to
+type SlackSinkConfig struct {
+	Enabled bool `json:"enabled"`
+	// Token is a user token with the users.profile:write scope
+	Token string `json:"token"`
+	// Text is a presence template for the status text
+	Text  string `json:"text"`
+	Emoji string `json:"emoji"`
+	// Expiration makes Slack clear the status by itself if the daemon stops
+	// updating it; zero keeps it until cleared
+	Expiration Duration `json:"expiration"`
+	// APIURL is the Slack Web API base URL, for testing against a stand-in
+	APIURL string `json:"api_url"`
+}
+
+// WebhookSinkConfig posts presence changes to an HTTP endpoint
+type WebhookSinkConfig struct {
+	// Name identifies the webhook in logs; it defaults to its position
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Body is a template for the JSON body; empty sends the default payload
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"`
+	// Secret signs the body with HMAC-SHA256 in the X-Signature-256 header
+	Secret string `json:"secret"`
+	// MinInterval is the shortest time between two requests; changes in
+	// between are merged into the next one
+	MinInterval Duration `json:"min_interval"`
+	// Retries is how often a failed request is retried, with exponential
+	// backoff, before it goes to the dead-letter log
+	Retries int `json:"retries"`
+}
+
+// PresenceConfig holds the templates for the two lines of the Discord
+// activity. They get the session fields and the Today, Week and Month totals.
+type PresenceConfig struct {
+	Details string `json:"details"`
+	State   string `json:"state"`
+}
+
+func (s SlackSinkConfig) validate() error {
+	if _, err := parseTemplate("sinks.slack.text", s.Text); err != nil {
+		return fmt.Errorf("sinks.slack.text: %w", err)
+	}
+	if s.Expiration < 0 {
+		return fmt.Errorf("sinks.slack.expiration must not be negative")
+	}
+	if s.Enabled && s.Token == "" {
+		return fmt.Errorf("sinks.slack.token must be set to enable the Slack sink")
+	}
+	return nil
+}
+
+// UnmarshalJSON fills in the defaults for keys a webhook leaves out
+func (w *WebhookSinkConfig) UnmarshalJSON(b []byte) error {
+	type plain WebhookSinkConfig
+	p := plain{MinInterval: Duration(time.Second), Retries: 3}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*w = WebhookSinkConfig(p)
+	return nil
+}
+
+func (w WebhookSinkConfig) validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL, got %q", w.URL)
+	}
+	if _, err := parseTemplate("body", w.Body); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	if w.MinInterval < 0 {
+		return fmt.Errorf("min_interval must not be negative")
+	}
+	if w.Retries < 0 {
+		return fmt.Errorf("retries must not be negative")
+	}
+	return nil
+}
+
+func (b BudgetConfig) validate() error {
+	if b.Daily < 0 || b.Monthly < 0 {
+		return fmt.Errorf("budgets must not be negative")
+	}
+	for name, limits := range b.Projects {
+		if limits.Daily < 0 || limits.Monthly < 0 {
+			return fmt.Errorf("budgets.projects.%s must not be negative", name)
+		}
+	}
+	if b.Notifier != "desktop" && b.Notifier != "log" {
+		return fmt.Errorf("budgets.notifier must be desktop or log, got %q", b.Notifier)
+	}
+	return nil
+}
+
+// StatusLineConfig controls the `statusline` command run by Claude Code
+type StatusLineConfig struct {
+	// Format is a template printed as the statusline text; empty prints nothing
+	Format string `json:"format"`
+	// Chain is a command that receives the statusline JSON after us, so an
+	// existing statusline keeps working
+	Chain string `json:"chain"`
+}
+
+// Duration is a time.Duration that reads and writes as a string like "3s"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"3s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// cfg is the configuration the current command runs with
+var cfg *Config
+
+func defaultConfig() *Config {
+	return &Config{
+		ClientID:     ClientID,
+		ClaudeDir:    claudeDir,
+		PollInterval: Duration(PollInterval),
+		Presence: PresenceConfig{
+			Details: defaultDetailsTemplate,
+			State:   defaultStateTemplate,
+		},
+		Sinks: SinksConfig{
+			Discord: DiscordSinkConfig{Enabled: true},
+			Slack: SlackSinkConfig{
+				Text:       defaultSlackTextTemplate,
+				Emoji:      ":robot_face:",
+				Expiration: Duration(30 * time.Minute),
+				APIURL:     slackAPIURL,
+			},
+			Overlay: OverlaySinkConfig{Listen: ":9465"},
+		},
+		Budgets: BudgetConfig{
+			Notifier: "desktop",
+		},
+		Log: LogConfig{
+			Level:     "info",
+			Format:    "text",
+			MaxSizeMB: 10,
+			MaxFiles:  3,
+		},
+	}
+}
+
+// defaultConfigPath returns ~/.claude/discord-presence-config.json
+func defaultConfigPath() string {
+	return filepath.Join(claudeDir, configFileName)
+}
+
+// loadConfig reads a config file on top of the defaults. A missing file is
+// not an error unless the path was given explicitly.
+func loadConfig(path string, explicit bool) (*Config, error) {
+	c := defaultConfig()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if c.ClientID == "" {
+		return fmt.Errorf("client_id must not be empty")
+	}
+	if c.ClaudeDir == "" {
+		return fmt.Errorf("claude_dir must not be empty")
+	}
+	if c.PollInterval <= 0 {
+		return fmt.Errorf("poll_interval must be positive")
+	}
+	if _, err := parseTemplate("statusline.format", c.StatusLine.Format); err != nil {
+		return fmt.Errorf("statusline.format: %w", err)
+	}
+	if _, err := parseTemplate("presence.details", c.Presence.Details); err != nil {
+		return fmt.Errorf("presence.details: %w", err)
+	}
+	if _, err := parseTemplate("presence.state", c.Presence.State); err != nil {
+		return fmt.Errorf("presence.state: %w", err)
+	}
+	if err := c.Sinks.Slack.validate(); err != nil {
+		return err
+	}
+	if _, _, err := net.SplitHostPort(c.Sinks.Overlay.Listen); err != nil {
+		return fmt.Errorf("sinks.overlay.listen: %w", err)
+	}
+	for i, w := range c.Sinks.Webhooks {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("sinks.webhooks[%d]: %w", i, err)
+		}
+	}
+	if err := c.Budgets.validate(); err != nil {
+		return err
+	}
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			return fmt.Errorf("metrics.listen: %w", err)
+		}
+	}
+	if _, err := c.Log.level(); err != nil {
+		return fmt.Errorf("log.level: %w", err)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		return fmt.Errorf("log.format must be text or json, got %q", c.Log.Format)
+	}
+	if c.Log.MaxSizeMB <= 0 {
+		return fmt.Errorf("log.max_size_mb must be positive")
+	}
+	if c.Log.MaxFiles < 0 {
+		return fmt.Errorf("log.max_files must not be negative")
+	}
+	return nil
+}
+
+// inQuietHours reports whether any quiet hours schedule covers t
+func (c *Config) inQuietHours(t time.Time) bool {
+	for _, s := range c.QuietHours {
+		if s.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// commonFlags are the flags shared by every subcommand
+type commonFlags struct {
+	configPath string
+	clientID   string
+	ipcPath    string
+	claudeDir  string
+
+	// Log flags are only registered by `run`
+	logLevel  string
+	logFormat string
+	logFile   string
+
+	defaultPath string
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.configPath, "config", "", "path to config file (default ~/.claude/"+configFileName+")")
+	fs.StringVar(&f.clientID, "client-id", "", "Discord application ID to use")
+	fs.StringVar(&f.ipcPath, "ipc-path", "", "Discord IPC socket or pipe to connect to (default: search for one)")
+	fs.StringVar(&f.claudeDir, "claude-dir", "", "Claude Code data directory (default ~/.claude)")
+}
+
+// registerLog adds the daemon's logging flags
+func (f *commonFlags) registerLog(fs *flag.FlagSet) {
+	fs.StringVar(&f.logLevel, "log-level", "", "log level: debug, info, warn or error (default info)")
+	fs.StringVar(&f.logFormat, "log-format", "", "log format: text or json (default text)")
+	fs.StringVar(&f.logFile, "log-file", "", "write the log to this file, rotating it by size (default stderr)")
+}
+
+// path returns the config file to read and whether it was given explicitly.
+// The default is in the --claude-dir directory if one is given, and is
+// resolved once, so a daemon keeps reloading the file it started with even
+// after claude_dir moved the data paths.
+func (f *commonFlags) path() (string, bool) {
+	if f.configPath != "" {
+		return f.configPath, true
+	}
+	if f.defaultPath == "" {
+		f.defaultPath = defaultConfigPath()
+		if f.claudeDir != "" {
+			f.defaultPath = filepath.Join(f.claudeDir, configFileName)
+		}
+	}
+	return f.defaultPath, false
+}
+
+// read loads the config file and applies flag overrides without making it
+// the current config
+func (f *commonFlags) read() (*Config, error) {
+	path, explicit := f.path()
+	c, err := loadConfig(path, explicit)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.clientID != "" {
+		c.ClientID = f.clientID
+	}
+	if f.ipcPath != "" {
+		c.Sinks.Discord.IPCPath = f.ipcPath
+	}
+	if f.claudeDir != "" {
+		c.ClaudeDir = f.claudeDir
+	}
+	if f.logLevel != "" {
+		c.Log.Level = f.logLevel
+	}
+	if f.logFormat != "" {
+		c.Log.Format = f.logFormat
+	}
+	if f.logFile != "" {
+		c.Log.File = f.logFile
+	}
+
+	// Flags can make a valid file invalid, e.g. --log-level=verbose
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the config and makes it current, pointing the global paths at
+// the configured Claude directory
+func (f *commonFlags) load() (*Config, error) {
+	c, err := f.read()
+	if err != nil {
+		return nil, err
+	}
+	setClaudeDir(c.ClaudeDir)
+	cfg = c
+	return c, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestLoadConfig tests reading config files on top of the defaults
+func TestLoadConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name         string
+		content      string
+		wantErr      bool
+		wantClientID string
+		wantPoll     time.Duration
+	}{
+		{
+			name:         "Empty object keeps defaults",
+			content:      `{}`,
+			wantClientID: ClientID,
+			wantPoll:     PollInterval,
+		},
+		{
+			name:         "Overrides client ID and poll interval",
+			content:      `{"client_id": "42", "poll_interval": "10s"}`,
+			wantClientID: "42",
+			wantPoll:     10 * time.Second,
+		},
+		{
+			name:    "Invalid duration",
+			content: `{"poll_interval": "soon"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Non-positive poll interval",
+			content: `{"poll_interval": "0s"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown log level",
+			content: `{"log": {"level": "verbose"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown log format",
+			content: `{"log": {"format": "xml"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid presence template",
+			content: `{"presence": {"state": "{{.ModelName"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Negative project budget",
+			content: `{"budgets": {"projects": {"app": {"daily": -1}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown notifier",
+			content: `{"budgets": {"notifier": "email"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Metrics listen address without port",
+			content: `{"metrics": {"listen": "localhost"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Slack sink without a token",
+			content: `{"sinks": {"slack": {"enabled": true}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Webhook without a URL",
+			content: `{"sinks": {"webhooks": [{"name": "dashboard"}]}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid JSON",
+			content: `{invalid`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, "config.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			got, err := loadConfig(path, true)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("loadConfig() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error: %v", err)
+			}
+			if got.ClientID != tt.wantClientID {
+				t.Errorf("ClientID = %q, want %q", got.ClientID, tt.wantClientID)
+			}
+			if time.Duration(got.PollInterval) != tt.wantPoll {
+				t.Errorf("PollInterval = %v, want %v", time.Duration(got.PollInterval), tt.wantPoll)
+			}
+		})
+	}
+
+	t.Run("Missing default file is not an error", func(t *testing.T) {
+		if _, err := loadConfig(filepath.Join(tmpDir, "missing.json"), false); err != nil {
+			t.Errorf("loadConfig() error: %v", err)
+		}
+	})
+
+	t.Run("Missing explicit file is an error", func(t *testing.T) {
+		if _, err := loadConfig(filepath.Join(tmpDir, "missing.json"), true); err == nil {
+			t.Error("Expected error for missing explicit config")
+		}
+	})
+}
+
+// TestCommonFlagsOverride tests that flags win over the config file
+func TestCommonFlagsOverride(t *testing.T) {
+	origClaudeDir, origCfg := claudeDir, cfg
+	defer func() {
+		setClaudeDir(origClaudeDir)
+		cfg = origCfg
+	}()
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"client_id": "from-file"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	flags := commonFlags{configPath: path, clientID: "from-flag", claudeDir: tmpDir}
+	got, err := flags.load()
+	if err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+	if got.ClientID != "from-flag" {
+		t.Errorf("ClientID = %q, want %q", got.ClientID, "from-flag")
+	}
+	if dataFilePath != filepath.Join(tmpDir, "discord-presence-data.json") {
+		t.Errorf("dataFilePath = %q, want it inside %q", dataFilePath, tmpDir)
+	}
+}
+
+// TestCommonFlagsDefaultPath tests that the default config file follows
+// --claude-dir, and that reloads read the file the command started with
+func TestCommonFlagsDefaultPath(t *testing.T) {
+	origClaudeDir, origCfg := claudeDir, cfg
+	defer func() {
+		setClaudeDir(origClaudeDir)
+		cfg = origCfg
+	}()
+
+	home, dir := t.TempDir(), t.TempDir()
+	setClaudeDir(home)
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(`{"client_id": "123"}`), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	flags := commonFlags{claudeDir: dir}
+	c, err := flags.load()
+	if err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+	if c.ClientID != "123" {
+		t.Errorf("client_id = %q, want the one from --claude-dir's config", c.ClientID)
+	}
+
+	// A claude_dir in the file does not move the file being reloaded
+	setClaudeDir(t.TempDir())
+	path, explicit := flags.path()
+	if want := filepath.Join(dir, configFileName); path != want || explicit {
+		t.Errorf("path() = %q, %v, want %q, false", path, explicit, want)
+	}
+}
+
+// TestRunCLIUnknownCommand tests that unknown commands exit with usage error
+func TestRunCLIUnknownCommand(t *testing.T) {
+	if code := runCLI([]string{"frobnicate"}); code != 2 {
+		t.Errorf("runCLI(frobnicate) = %d, want 2", code)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// Presence state shared between the watch loop and the control API
+var (
+	daemonMu    sync.Mutex
+	lastSession *SessionData
+	paused      bool
+	override    *presenceOverride
+
+	// daemonFlags are the flags `run` was started with, kept so a reload
+	// applies the same overrides on top of the re-read config file
+	daemonFlags commonFlags
+)
+
+// presenceOverride temporarily replaces the details and/or state line
+type presenceOverride struct {
+	Details string `json:"details,omitempty"`
+	State   string `json:"state,omitempty"`
+	// Until is when the override expires; zero means it never does
+	Until time.Time `json:"until,omitempty"`
+}
+
+func (o *presenceOverride) active() bool {
+	if o == nil || (o.Details == "" && o.State == "") {
+		return false
+	}
+	return o.Until.IsZero() || time.Now().Before(o.Until)
+}
+
+// overrideRequest is the body of POST /override
+type overrideRequest struct {
+	Details string `json:"details"`
+	State   string `json:"state"`
+	// Duration is how long the override lasts, e.g. "30m"; empty means
+	// until it is cleared
+	Duration Duration `json:"duration"`
+}
+
+// controlError is the body of every failed control API response
+type controlError struct {
+	Error string `json:"error"`
+}
+
+// newControlHandler returns the control API served on the control socket
+func newControlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", handleStatus)
+	mux.HandleFunc("GET /presence", handlePresence)
+	mux.HandleFunc("POST /pause", handlePause)
+	mux.HandleFunc("POST /resume", handleResume)
+	mux.HandleFunc("POST /override", handleOverride)
+	mux.HandleFunc("DELETE /override", handleClearOverride)
+	mux.HandleFunc("POST /reload", handleReload)
+	mux.HandleFunc("POST /stop", handleStop)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, controlError{Error: err.Error()})
+}
+
+// respondState saves and returns the daemon state after a change. Callers
+// must hold daemonMu.
+func respondState(w http.ResponseWriter) {
+	if err := saveDaemonState(); err != nil {
+		slog.Error("Failed to save daemon state", "err", err)
+	}
+	writeJSON(w, http.StatusOK, currentDaemonState())
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+	writeJSON(w, http.StatusOK, currentDaemonState())
+}
+
+// handlePresence returns the presence as it is rendered right now, for
+// `print` and `watch`
+func handlePresence(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	if lastSession == nil {
+		writeJSON(w, http.StatusOK, presenceSnapshot{})
+		return
+	}
+	writeJSON(w, http.StatusOK, newPresenceSnapshot(buildPresence(lastSession), !presenceHidden()))
+}
+
+func handlePause(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	setPaused(true)
+	respondState(w)
+}
+
+func handleResume(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	setPaused(false)
+	respondState(w)
+}
+
+func handleOverride(w http.ResponseWriter, r *http.Request) {
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid override: %w", err))
+		return
+	}
+	if req.Duration < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("duration must not be negative"))
+		return
+	}
+
+	// An empty override is the same as clearing it
+	if req.Details == "" && req.State == "" {
+		handleClearOverride(w, r)
+		return
+	}
+
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	override = &presenceOverride{Details: req.Details, State: req.State}
+	if req.Duration > 0 {
+		override.Until = time.Now().Add(time.Duration(req.Duration))
+	}
+	rerenderPresence()
+	respondState(w)
+}
+
+func handleClearOverride(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	if override != nil {
+		override = nil
+		rerenderPresence()
+	}
+	respondState(w)
+}
+
+// expireOverride drops an override whose time is up and takes it off the
+// sinks. Without a session there is nothing to render in its place, so the
+// presence is cleared. Callers must hold daemonMu.
+func expireOverride() {
+	if override == nil || override.active() {
+		return
+	}
+	override = nil
+	slog.Info("Presence override expired")
+	if presenceHidden() {
+		return
+	}
+	if lastSession == nil {
+		sinks.clear()
+		return
+	}
+	rerenderPresence()
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	if err := reportReload(reloadConfig()); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	respondState(w)
+}
+
+// stopRequested is closed when the control API asks the daemon to exit
+var (
+	stopRequested = make(chan struct{})
+	stopOnce      sync.Once
+)
+
+// handleStop answers with the daemon's state, so the caller knows which
+// process to wait for, and then shuts the daemon down
+func handleStop(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	writeJSON(w, http.StatusOK, currentDaemonState())
+	daemonMu.Unlock()
+
+	// The response has to be out before the daemon exits
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	stopOnce.Do(func() { close(stopRequested) })
+}
+
+// reloadConfig re-reads the config the daemon was started with and applies
+// it. The old config stays in place if the new one is invalid. Callers must
+// hold daemonMu.
+func reloadConfig() error {
+	next, err := daemonFlags.read()
+	if err != nil {
+		return err
+	}
+	if next.ClaudeDir != cfg.ClaudeDir {
+		return fmt.Errorf("claude_dir cannot change while the daemon is running, restart it instead")
+	}
+
+	sinks = reopenSinks(sinks, next)
+	listenMetrics(next.Metrics)
+
+	// Only the level can change on the fly, the log output is kept
+	if was, now := cfg.Log, next.Log; was.Format != now.Format || was.File != now.File ||
+		was.MaxSizeMB != now.MaxSizeMB || was.MaxFiles != now.MaxFiles {
+		slog.Warn("Log format, file and rotation changes take effect after a restart")
+	}
+	cfg = next
+	level, _ := cfg.Log.level()
+	logLevel.Set(level)
+	checkQuietHours(time.Now())
+	rerenderPresence()
+	return nil
+}
+
+// reportReload logs the outcome of a reload and passes its error through
+func reportReload(err error) error {
+	if err != nil {
+		slog.Error("Config reload failed, keeping the current config", "err", err)
+		return err
+	}
+	slog.Info("Config reloaded")
+	return nil
+}
+
+// watchControlSignals handles the pause, resume and reload signals, where the
+// platform has them
+func watchControlSignals() {
+	if reloadSignal == nil {
+		return
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, pauseSignal, resumeSignal, reloadSignal)
+	go func() {
+		for sig := range sigs {
+			daemonMu.Lock()
+			switch sig {
+			case pauseSignal:
+				setPaused(true)
+			case resumeSignal:
+				setPaused(false)
+			case reloadSignal:
+				reportReload(reloadConfig())
+			}
+			if err := saveDaemonState(); err != nil {
+				slog.Error("Failed to save daemon state", "err", err)
+			}
+			daemonMu.Unlock()
+		}
+	}()
+}
+
+// controlServer is the daemon's control API, nil until it is served
+var controlServer *http.Server
+
+// serveControl starts the control API. The daemon lock must be held, since a
+// stale control socket is replaced.
+func serveControl() error {
+	l, err := listenControl()
+	if err != nil {
+		return err
+	}
+
+	controlServer = &http.Server{Handler: newControlHandler()}
+	go controlServer.Serve(l)
+	return nil
+}
+
+// stopControl stops the control API. From then on the daemon no longer
+// counts as running, so attach starts a new one.
+func stopControl() {
+	if controlServer != nil {
+		controlServer.Close()
+	}
+}
+
+// controlClient talks to the running daemon over the control socket
+var controlClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialControl(ctx)
+		},
+	},
+}
+
+// callControl sends a request to the daemon's control API and decodes the
+// response into out, if given
+func callControl(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// The host is ignored, the transport always dials the control socket
+	req, err := http.NewRequest(method, "http://daemon"+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := controlClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e controlError
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return errors.New(e.Error)
+		}
+		return fmt.Errorf("control API returned %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
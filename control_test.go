@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupControlTest points the daemon globals at a temp dir and no sinks, so
+// presence updates go nowhere
+func setupControlTest(t *testing.T) {
+	t.Helper()
+	origClaudeDir, origCfg, origSinks := claudeDir, cfg, sinks
+	t.Cleanup(func() {
+		setClaudeDir(origClaudeDir)
+		cfg, sinks = origCfg, origSinks
+		paused, quiet, override, lastSession = false, false, nil, nil
+		daemonFlags = commonFlags{}
+	})
+
+	setClaudeDir(t.TempDir())
+	cfg = defaultConfig()
+	sinks = nil
+	paused, quiet, override, lastSession = false, false, nil, nil
+	daemonFlags = commonFlags{}
+}
+
+func controlRequest(t *testing.T, method, path, body string) (*httptest.ResponseRecorder, DaemonState) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	newControlHandler().ServeHTTP(rec, req)
+
+	var state DaemonState
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+			t.Fatalf("%s %s: invalid response %q: %v", method, path, rec.Body, err)
+		}
+	}
+	return rec, state
+}
+
+// TestControlPauseResume tests pausing and resuming presence
+func TestControlPauseResume(t *testing.T) {
+	setupControlTest(t)
+
+	rec, state := controlRequest(t, http.MethodPost, "/pause", "")
+	if rec.Code != http.StatusOK || !state.Paused || !paused {
+		t.Fatalf("POST /pause = %d, paused %v", rec.Code, state.Paused)
+	}
+
+	saved, err := readDaemonState()
+	if err != nil || !saved.Paused {
+		t.Errorf("state file should record the pause, got %+v (%v)", saved, err)
+	}
+
+	rec, state = controlRequest(t, http.MethodPost, "/resume", "")
+	if rec.Code != http.StatusOK || state.Paused || paused {
+		t.Errorf("POST /resume = %d, paused %v", rec.Code, state.Paused)
+	}
+
+	if rec, _ := controlRequest(t, http.MethodGet, "/pause", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// TestControlOverride tests setting, expiring and clearing an override
+func TestControlOverride(t *testing.T) {
+	setupControlTest(t)
+
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		code    int
+		details string
+		expires bool
+	}{
+		{"set", http.MethodPost, `{"details": "Reviewing PRs"}`, http.StatusOK, "Reviewing PRs", false},
+		{"with duration", http.MethodPost, `{"state": "Pairing", "duration": "30m"}`, http.StatusOK, "", true},
+		{"invalid duration", http.MethodPost, `{"state": "x", "duration": "soon"}`, http.StatusBadRequest, "", false},
+		{"negative duration", http.MethodPost, `{"state": "x", "duration": "-1m"}`, http.StatusBadRequest, "", false},
+		{"empty clears", http.MethodPost, ``, http.StatusOK, "", false},
+		{"delete clears", http.MethodDelete, ``, http.StatusOK, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, state := controlRequest(t, tt.method, "/override", tt.body)
+			if rec.Code != tt.code {
+				t.Fatalf("%s /override = %d, want %d (%s)", tt.method, rec.Code, tt.code, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if tt.details != "" && (state.Override == nil || state.Override.Details != tt.details) {
+				t.Errorf("override = %+v, want details %q", state.Override, tt.details)
+			}
+			if tt.expires && (state.Override == nil || state.Override.Until.IsZero()) {
+				t.Errorf("override = %+v, want an expiry", state.Override)
+			}
+			if tt.body == "" && state.Override != nil {
+				t.Errorf("override = %+v, want cleared", state.Override)
+			}
+		})
+	}
+}
+
+// TestPresenceOverrideActive tests override expiry
+func TestPresenceOverrideActive(t *testing.T) {
+	tests := []struct {
+		name string
+		o    *presenceOverride
+		want bool
+	}{
+		{"nil", nil, false},
+		{"empty", &presenceOverride{}, false},
+		{"no expiry", &presenceOverride{State: "x"}, true},
+		{"future", &presenceOverride{State: "x", Until: time.Now().Add(time.Minute)}, true},
+		{"expired", &presenceOverride{State: "x", Until: time.Now().Add(-time.Minute)}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.o.active(); got != tt.want {
+				t.Errorf("active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestOverrideExpiresWithoutSession tests that an override set before any
+// session was seen comes off the sinks once it expires
+func TestOverrideExpiresWithoutSession(t *testing.T) {
+	setupControlTest(t)
+	sink := newTestSink("test")
+	sinks = sinkSet{newSinkRunner(sink)}
+	defer sinks.close()
+
+	if rec, _ := controlRequest(t, http.MethodPost, "/override", `{"details":"Pairing","duration":"1h"}`); rec.Code != http.StatusOK {
+		t.Fatalf("POST /override = %d (%s)", rec.Code, rec.Body)
+	}
+	sink.wait(t, 1)
+
+	daemonMu.Lock()
+	override.Until = time.Now().Add(-time.Second)
+	daemonMu.Unlock()
+	if session := refreshSession(); session != nil {
+		t.Fatalf("refreshSession() found a session in an empty claude dir: %+v", session)
+	}
+	sink.wait(t, 1)
+
+	if got := sink.ops(); len(got) != 2 || got[0] != "Pairing" || got[1] != "clear" {
+		t.Errorf("sink got %v, want [Pairing clear]", got)
+	}
+	if override != nil {
+		t.Errorf("override = %+v, want it dropped after expiring", override)
+	}
+}
+
+// TestControlReload tests that reload applies a valid config and keeps the
+// old one when the new one is invalid
+func TestControlReload(t *testing.T) {
+	setupControlTest(t)
+	path := filepath.Join(claudeDir, "config.json")
+	daemonFlags.configPath = path
+
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	write(`{"poll_interval": "10s"}`)
+	if rec, _ := controlRequest(t, http.MethodPost, "/reload", ""); rec.Code != http.StatusOK {
+		t.Fatalf("POST /reload = %d (%s)", rec.Code, rec.Body)
+	}
+	if cfg.PollInterval != Duration(10*time.Second) {
+		t.Errorf("poll_interval = %v, want 10s", cfg.PollInterval)
+	}
+
+	for _, content := range []string{
+		`{"poll_interval": "0s"}`,
+		`{"claude_dir": "/somewhere/else"}`,
+	} {
+		write(content)
+		rec, _ := controlRequest(t, http.MethodPost, "/reload", "")
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("reload with %s = %d, want %d", content, rec.Code, http.StatusUnprocessableEntity)
+		}
+		if cfg.PollInterval != Duration(10*time.Second) {
+			t.Errorf("failed reload changed poll_interval to %v", cfg.PollInterval)
+		}
+	}
+}
+
+// TestControlReloadMetrics tests that a reload moves the metrics endpoint
+// to the new address and stops it when metrics are turned off
+func TestControlReloadMetrics(t *testing.T) {
+	setupControlTest(t)
+	path := filepath.Join(claudeDir, "config.json")
+	daemonFlags.configPath = path
+	defer listenMetrics(MetricsConfig{})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	scrape := func() error {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for _, tt := range []struct {
+		config  string
+		serving bool
+	}{
+		{`"metrics": {"listen": "` + addr + `"}`, true},
+		{`"metrics": {}`, false},
+	} {
+		config := `{"sinks": {"discord": {"enabled": false}}, ` + tt.config + `}`
+		if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if rec, _ := controlRequest(t, http.MethodPost, "/reload", ""); rec.Code != http.StatusOK {
+			t.Fatalf("POST /reload = %d (%s)", rec.Code, rec.Body)
+		}
+		if err := scrape(); (err == nil) != tt.serving {
+			t.Errorf("after reloading %s, scrape error = %v", tt.config, err)
+		}
+	}
+}
+
+// TestCallControl tests the client against a daemon listening on the
+// control socket
+func TestCallControl(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("control pipe names are global on Windows")
+	}
+	setupControlTest(t)
+
+	if err := callControl(http.MethodGet, "/status", nil, nil); err == nil {
+		t.Error("callControl() should fail with no daemon listening")
+	}
+
+	if err := serveControl(); err != nil {
+		t.Fatalf("serveControl() error: %v", err)
+	}
+	defer stopControl()
+
+	var state DaemonState
+	if err := callControl(http.MethodPost, "/override", overrideRequest{State: "Focusing"}, &state); err != nil {
+		t.Fatalf("callControl() error: %v", err)
+	}
+	if state.Override == nil || state.Override.State != "Focusing" {
+		t.Errorf("override = %+v, want state %q", state.Override, "Focusing")
+	}
+
+	err := callControl(http.MethodPost, "/override", map[string]string{"duration": "soon"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid override") {
+		t.Errorf("callControl() error = %v, want the API's error message", err)
+	}
+
+	info, err := os.Stat(controlSocketPath())
+	if err != nil {
+		t.Fatalf("control socket missing: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("control socket mode = %v, want 0600", info.Mode().Perm())
+	}
+}
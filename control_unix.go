@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+func controlSocketPath() string {
+	return filepath.Join(claudeDir, "discord-presence.sock")
+}
+
+// listenControl listens on the control socket, replacing one left behind by
+// a daemon that crashed. Only the owner may connect.
+func listenControl() (net.Listener, error) {
+	path := controlSocketPath()
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func dialControl(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", controlSocketPath())
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+
+	"github.com/Microsoft/go-winio"
+)
+
+// controlSocketPath returns the control pipe name. Daemons for different
+// Claude directories each get their own pipe.
+func controlSocketPath() string {
+	h := fnv.New32a()
+	h.Write([]byte(claudeDir))
+	return fmt.Sprintf(`\\.\pipe\cc-discord-presence-%08x`, h.Sum32())
+}
+
+// listenControl listens on the control pipe. The default pipe security only
+// lets the owner and administrators connect.
+func listenControl() (net.Listener, error) {
+	return winio.ListenPipe(controlSocketPath(), nil)
+}
+
+func dialControl(ctx context.Context) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, controlSocketPath())
+}
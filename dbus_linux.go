@@ -0,0 +1,365 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+// The D-Bus sink publishes the session under its own name and as an MPRIS
+// media player, which most desktop panels already know how to show
+const (
+	dbusName         = "io.github.tsanva.CcDiscordPresence"
+	dbusPath         = "/io/github/tsanva/CcDiscordPresence"
+	dbusSessionIface = "io.github.tsanva.CcDiscordPresence.Session"
+
+	mprisName        = "org.mpris.MediaPlayer2.cc_discord_presence"
+	mprisPath        = "/org/mpris/MediaPlayer2"
+	mprisIface       = "org.mpris.MediaPlayer2"
+	mprisPlayerIface = "org.mpris.MediaPlayer2.Player"
+	mprisNoTrack     = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+)
+
+// dbusSink publishes the presence on the session bus
+type dbusSink struct {
+	conn    *dbus.Conn
+	session *dbusProperties
+	mpris   *dbusProperties
+
+	mu    sync.Mutex
+	start time.Time
+}
+
+func newDBusSink() (Sink, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the session bus: %w", err)
+	}
+	s, err := newDBusSinkConn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newDBusSinkConn exports the objects on conn and then takes the bus names,
+// so no one sees the names before the objects answer
+func newDBusSinkConn(conn *dbus.Conn) (*dbusSink, error) {
+	s := &dbusSink{conn: conn}
+
+	s.session = newDBusProperties(conn, dbusPath, map[string]map[string]dbus.Variant{
+		dbusSessionIface: sessionProperties(Presence{}, false),
+	})
+	s.session.live[dbusSessionIface] = map[string]func() any{
+		"Elapsed": func() any { return int64(s.elapsed() / time.Second) },
+	}
+
+	s.mpris = newDBusProperties(conn, mprisPath, map[string]map[string]dbus.Variant{
+		mprisIface: {
+			"Identity":            dbus.MakeVariant("Claude Code"),
+			"CanQuit":             dbus.MakeVariant(false),
+			"CanRaise":            dbus.MakeVariant(false),
+			"HasTrackList":        dbus.MakeVariant(false),
+			"SupportedUriSchemes": dbus.MakeVariant([]string{}),
+			"SupportedMimeTypes":  dbus.MakeVariant([]string{}),
+		},
+		mprisPlayerIface: playerProperties(Presence{}, false),
+	})
+	s.mpris.live[mprisPlayerIface] = map[string]func() any{
+		// Position is in microseconds and, as MPRIS asks, never signalled:
+		// players extrapolate it from Rate while PlaybackStatus is Playing
+		"Position": func() any { return int64(s.elapsed() / time.Microsecond) },
+	}
+
+	exports := []struct {
+		v     any
+		path  dbus.ObjectPath
+		iface string
+	}{
+		{s.session, dbusPath, "org.freedesktop.DBus.Properties"},
+		{introspect.NewIntrospectable(s.session.node()), dbusPath, "org.freedesktop.DBus.Introspectable"},
+		{s.mpris, mprisPath, "org.freedesktop.DBus.Properties"},
+		{introspect.NewIntrospectable(s.mpris.node()), mprisPath, "org.freedesktop.DBus.Introspectable"},
+		{mprisRoot{}, mprisPath, mprisIface},
+	}
+	for _, e := range exports {
+		if err := conn.Export(e.v, e.path, e.iface); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.ExportWithMap(mprisPlayer{}, mprisPlayerNames, mprisPath, mprisPlayerIface); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{dbusName, mprisName} {
+		reply, err := conn.RequestName(name, dbus.NameFlagDoNotQueue)
+		if err != nil {
+			return nil, fmt.Errorf("requesting %s: %w", name, err)
+		}
+		if reply != dbus.RequestNameReplyPrimaryOwner {
+			return nil, fmt.Errorf("%s is already taken by another process", name)
+		}
+	}
+	return s, nil
+}
+
+func (s *dbusSink) Name() string { return "dbus" }
+
+func (s *dbusSink) Update(p Presence) error {
+	return s.publish(p, true)
+}
+
+func (s *dbusSink) Clear() error {
+	return s.publish(Presence{}, false)
+}
+
+func (s *dbusSink) Close() error {
+	s.conn.ReleaseName(dbusName)
+	s.conn.ReleaseName(mprisName)
+	return s.conn.Close()
+}
+
+func (s *dbusSink) publish(p Presence, visible bool) error {
+	s.mu.Lock()
+	s.start = time.Time{}
+	if visible && p.Activity.StartTime != nil {
+		s.start = *p.Activity.StartTime
+	}
+	s.mu.Unlock()
+
+	if err := s.session.update(dbusSessionIface, sessionProperties(p, visible)); err != nil {
+		return err
+	}
+	return s.mpris.update(mprisPlayerIface, playerProperties(p, visible))
+}
+
+// elapsed is how long the shown session has been running, zero while hidden
+func (s *dbusSink) elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.start.IsZero() {
+		return 0
+	}
+	return time.Since(s.start)
+}
+
+// sessionProperties are the properties of the session interface. Elapsed is
+// added when read.
+func sessionProperties(p Presence, visible bool) map[string]dbus.Variant {
+	session := p.Data.SessionData
+	if !visible || session == nil {
+		session = &SessionData{}
+	}
+	var start int64
+	if visible && p.Activity.StartTime != nil {
+		start = p.Activity.StartTime.Unix()
+	}
+	return map[string]dbus.Variant{
+		"Visible":     dbus.MakeVariant(visible && p.Data.SessionData != nil),
+		"Details":     dbus.MakeVariant(p.Activity.Details),
+		"State":       dbus.MakeVariant(p.Activity.State),
+		"Project":     dbus.MakeVariant(session.ProjectName),
+		"Branch":      dbus.MakeVariant(session.GitBranch),
+		"Model":       dbus.MakeVariant(session.ModelName),
+		"TotalTokens": dbus.MakeVariant(session.TotalTokens),
+		"TotalCost":   dbus.MakeVariant(session.TotalCost),
+		"StartTime":   dbus.MakeVariant(start),
+		"CurrentTool": dbus.MakeVariant(session.CurrentTool),
+		"TodayCost":   dbus.MakeVariant(p.Data.Today.Cost),
+	}
+}
+
+// playerProperties show the session as the track of an MPRIS player: the
+// details line as the title, the state line as the artist and the project
+// as the album. Position is added when read.
+func playerProperties(p Presence, visible bool) map[string]dbus.Variant {
+	status := "Stopped"
+	metadata := map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(dbus.ObjectPath(mprisNoTrack)),
+	}
+	if session := p.Data.SessionData; visible && session != nil {
+		status = "Playing"
+		metadata = map[string]dbus.Variant{
+			"mpris:trackid": dbus.MakeVariant(mprisTrackID(session.SessionID)),
+			"xesam:title":   dbus.MakeVariant(p.Activity.Details),
+			"xesam:artist":  dbus.MakeVariant([]string{p.Activity.State}),
+			"xesam:album":   dbus.MakeVariant(session.ProjectName),
+		}
+	}
+
+	no := dbus.MakeVariant(false)
+	return map[string]dbus.Variant{
+		"PlaybackStatus": dbus.MakeVariant(status),
+		"Metadata":       dbus.MakeVariant(metadata),
+		"Rate":           dbus.MakeVariant(1.0),
+		"MinimumRate":    dbus.MakeVariant(1.0),
+		"MaximumRate":    dbus.MakeVariant(1.0),
+		"Volume":         dbus.MakeVariant(1.0),
+		"CanGoNext":      no,
+		"CanGoPrevious":  no,
+		"CanPlay":        no,
+		"CanPause":       no,
+		"CanSeek":        no,
+		"CanControl":     no,
+	}
+}
+
+// mprisTrackID turns a session ID into a track object path, which only
+// allows letters, digits and underscores in each element
+func mprisTrackID(sessionID string) dbus.ObjectPath {
+	id := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, sessionID)
+	if id == "" {
+		id = "current"
+	}
+	return dbus.ObjectPath(dbusPath + "/session/" + id)
+}
+
+// mprisRoot and mprisPlayer are the MPRIS methods. The player reports that
+// it cannot be controlled, so every method does nothing.
+type mprisRoot struct{}
+
+func (mprisRoot) Raise() *dbus.Error { return nil }
+func (mprisRoot) Quit() *dbus.Error  { return nil }
+
+type mprisPlayer struct{}
+
+// mprisPlayerNames maps methods named differently in Go, because go vet
+// expects Seek to be io.Seeker's
+var mprisPlayerNames = map[string]string{"SeekBy": "Seek"}
+
+func (mprisPlayer) Next() *dbus.Error                                             { return nil }
+func (mprisPlayer) Previous() *dbus.Error                                         { return nil }
+func (mprisPlayer) Pause() *dbus.Error                                            { return nil }
+func (mprisPlayer) PlayPause() *dbus.Error                                        { return nil }
+func (mprisPlayer) Stop() *dbus.Error                                             { return nil }
+func (mprisPlayer) Play() *dbus.Error                                             { return nil }
+func (mprisPlayer) SeekBy(offset int64) *dbus.Error                               { return nil }
+func (mprisPlayer) SetPosition(track dbus.ObjectPath, position int64) *dbus.Error { return nil }
+func (mprisPlayer) OpenUri(uri string) *dbus.Error                                { return nil }
+
+// dbusProperties implements org.freedesktop.DBus.Properties for one object.
+// Unlike the prop package it signals all properties that changed in one
+// update together, and only those.
+type dbusProperties struct {
+	conn *dbus.Conn
+	path dbus.ObjectPath
+
+	mu     sync.Mutex
+	values map[string]map[string]dbus.Variant
+	// live properties are computed when read and never signalled
+	live map[string]map[string]func() any
+}
+
+func newDBusProperties(conn *dbus.Conn, path dbus.ObjectPath, values map[string]map[string]dbus.Variant) *dbusProperties {
+	return &dbusProperties{conn: conn, path: path, values: values, live: map[string]map[string]func() any{}}
+}
+
+func (d *dbusProperties) Get(iface, property string) (dbus.Variant, *dbus.Error) {
+	all, err := d.GetAll(iface)
+	if err != nil {
+		return dbus.Variant{}, err
+	}
+	v, ok := all[property]
+	if !ok {
+		return dbus.Variant{}, prop.ErrPropNotFound
+	}
+	return v, nil
+}
+
+func (d *dbusProperties) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	values, ok := d.values[iface]
+	if !ok {
+		return nil, prop.ErrIfaceNotFound
+	}
+	all := make(map[string]dbus.Variant, len(values)+len(d.live[iface]))
+	for name, v := range values {
+		all[name] = v
+	}
+	for name, get := range d.live[iface] {
+		all[name] = dbus.MakeVariant(get())
+	}
+	return all, nil
+}
+
+func (d *dbusProperties) Set(iface, property string, value dbus.Variant) *dbus.Error {
+	if _, err := d.Get(iface, property); err != nil {
+		return err
+	}
+	return prop.ErrReadOnly
+}
+
+// update stores new values for an interface's properties and emits one
+// PropertiesChanged signal with those that changed
+func (d *dbusProperties) update(iface string, values map[string]dbus.Variant) error {
+	d.mu.Lock()
+	changed := map[string]dbus.Variant{}
+	for name, v := range values {
+		if old, ok := d.values[iface][name]; !ok || !reflect.DeepEqual(old.Value(), v.Value()) {
+			changed[name] = v
+		}
+		d.values[iface][name] = v
+	}
+	d.mu.Unlock()
+
+	if len(changed) == 0 {
+		return nil
+	}
+	return d.conn.Emit(d.path, "org.freedesktop.DBus.Properties.PropertiesChanged", iface, changed, []string{})
+}
+
+// node describes the object for introspection
+func (d *dbusProperties) node() *introspect.Node {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	node := &introspect.Node{
+		Name:       string(d.path),
+		Interfaces: []introspect.Interface{introspect.IntrospectData, prop.IntrospectData},
+	}
+	for _, iface := range sortedKeys(d.values) {
+		values := d.values[iface]
+		i := introspect.Interface{Name: iface}
+		switch iface {
+		case mprisIface:
+			i.Methods = introspect.Methods(mprisRoot{})
+		case mprisPlayerIface:
+			i.Methods = introspect.Methods(mprisPlayer{})
+			for j, m := range i.Methods {
+				if name, ok := mprisPlayerNames[m.Name]; ok {
+					i.Methods[j].Name = name
+				}
+			}
+		}
+		for _, name := range sortedKeys(values) {
+			i.Properties = append(i.Properties, introspect.Property{
+				Name: name, Type: values[name].Signature().String(), Access: "read",
+			})
+		}
+		for _, name := range sortedKeys(d.live[iface]) {
+			i.Properties = append(i.Properties, introspect.Property{
+				Name: name, Type: dbus.SignatureOf(d.live[iface][name]()).String(), Access: "read",
+				Annotations: []introspect.Annotation{
+					{Name: "org.freedesktop.DBus.Property.EmitsChangedSignal", Value: "false"},
+				},
+			})
+		}
+		node.Interfaces = append(node.Interfaces, i)
+	}
+	return node
+}
@@ -0,0 +1,188 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// privateBusConfig is a minimal session bus that lets everyone own names
+const privateBusConfig = `<busconfig>
+  <type>session</type>
+  <listen>unix:path=%s</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`
+
+// privateBus starts a dbus-daemon for the test and returns two connections
+// to it, or skips the test where dbus-daemon is not installed
+func privateBus(t *testing.T) (*dbus.Conn, *dbus.Conn) {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	socket := filepath.Join(dir, "bus")
+	if err := os.WriteFile(config, []byte(fmt.Sprintf(privateBusConfig, socket)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	// The address is printed once the bus is ready
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the bus address: %v", err)
+	}
+
+	conns := make([]*dbus.Conn, 2)
+	for i := range conns {
+		if conns[i], err = dbus.Connect(strings.TrimSpace(address)); err != nil {
+			t.Fatalf("connecting to the private bus: %v", err)
+		}
+		t.Cleanup(func() { conns[i].Close() })
+	}
+	return conns[0], conns[1]
+}
+
+// TestDBusSink tests publishing the presence on a private session bus
+func TestDBusSink(t *testing.T) {
+	sinkConn, client := privateBus(t)
+
+	sink, err := newDBusSinkConn(sinkConn)
+	if err != nil {
+		t.Fatalf("newDBusSinkConn() error: %v", err)
+	}
+
+	if err := client.AddMatchSignal(dbus.WithMatchInterface("org.freedesktop.DBus.Properties"), dbus.WithMatchMember("PropertiesChanged")); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 10)
+	client.Signal(signals)
+
+	session := client.Object(dbusName, dbusPath)
+	player := client.Object(mprisName, mprisPath)
+	get := func(obj dbus.BusObject, iface, name string) any {
+		t.Helper()
+		v, err := obj.GetProperty(iface + "." + name)
+		if err != nil {
+			t.Fatalf("getting %s.%s: %v", iface, name, err)
+		}
+		return v.Value()
+	}
+
+	if got := get(session, dbusSessionIface, "Visible"); got != false {
+		t.Errorf("Visible before any update = %v, want false", got)
+	}
+
+	start := time.Now().Add(-90 * time.Second)
+	p := Presence{}
+	p.Data.SessionData = &SessionData{SessionID: "abc-123", ProjectName: "demo", ModelName: "Opus 4.5", TotalTokens: 1500, TotalCost: 0.25}
+	p.Activity.Details = "Working on: demo"
+	p.Activity.State = "Opus 4.5 | 1.5K tokens | $0.2500"
+	p.Activity.StartTime = &start
+	if err := sink.Update(p); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+
+	for name, want := range map[string]any{
+		"Visible":     true,
+		"Project":     "demo",
+		"Model":       "Opus 4.5",
+		"TotalTokens": int64(1500),
+		"TotalCost":   0.25,
+		"StartTime":   start.Unix(),
+	} {
+		if got := get(session, dbusSessionIface, name); got != want {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if elapsed := get(session, dbusSessionIface, "Elapsed").(int64); elapsed < 90 {
+		t.Errorf("Elapsed = %d, want at least 90", elapsed)
+	}
+
+	if got := get(player, mprisPlayerIface, "PlaybackStatus"); got != "Playing" {
+		t.Errorf("PlaybackStatus = %v, want Playing", got)
+	}
+	metadata := get(player, mprisPlayerIface, "Metadata").(map[string]dbus.Variant)
+	if title := metadata["xesam:title"].Value(); title != "Working on: demo" {
+		t.Errorf("xesam:title = %v, want the details line", title)
+	}
+	if id := metadata["mpris:trackid"].Value(); id != dbus.ObjectPath(dbusPath+"/session/abc_123") {
+		t.Errorf("mpris:trackid = %v", id)
+	}
+
+	// One signal per interface, carrying only what changed
+	changed := map[string]map[string]dbus.Variant{}
+	for range 2 {
+		select {
+		case sig := <-signals:
+			changed[sig.Body[0].(string)] = sig.Body[1].(map[string]dbus.Variant)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no PropertiesChanged signal")
+		}
+	}
+	if v, ok := changed[dbusSessionIface]["Project"]; !ok || v.Value() != "demo" {
+		t.Errorf("session signal = %v, want Project changed", changed[dbusSessionIface])
+	}
+	if _, ok := changed[dbusSessionIface]["Branch"]; ok {
+		t.Error("session signal should not include unchanged properties")
+	}
+	if _, ok := changed[mprisPlayerIface]["PlaybackStatus"]; !ok {
+		t.Errorf("player signal = %v, want PlaybackStatus changed", changed[mprisPlayerIface])
+	}
+
+	// Repeating the same presence signals nothing
+	if err := sink.Update(p); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if err := sink.Clear(); err != nil {
+		t.Fatalf("Clear() error: %v", err)
+	}
+	sig := <-signals
+	if sig.Body[0] != dbusSessionIface {
+		t.Fatalf("first signal after Clear() is for %v, want the session interface", sig.Body[0])
+	}
+	if v := sig.Body[1].(map[string]dbus.Variant)["Visible"]; v.Value() != false {
+		t.Errorf("Clear() signalled Visible = %v, want false", v)
+	}
+	if got := get(player, mprisPlayerIface, "PlaybackStatus"); got != "Stopped" {
+		t.Errorf("PlaybackStatus after Clear() = %v, want Stopped", got)
+	}
+
+	// Properties are read-only
+	if err := session.SetProperty(dbusSessionIface+".Project", dbus.MakeVariant("x")); err == nil {
+		t.Error("setting a property should fail")
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Errorf("Close() error: %v", err)
+	}
+}
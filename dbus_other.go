@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// newDBusSink fails outside Linux, where there is no session bus to publish on
+func newDBusSink() (Sink, error) {
+	return nil, errors.New("the D-Bus sink is only available on Linux")
+}
@@ -2,10 +2,15 @@ package discord
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -13,8 +18,15 @@ import (
 const (
 	opHandshake = 0
 	opFrame     = 1
+	opClose     = 2
+	opPing      = 3
+	opPong      = 4
 )
 
+// maxFrameSize guards against a corrupt frame header asking for a huge
+// payload; Discord's own frames are a few kilobytes at most
+const maxFrameSize = 1 << 20
+
 // Activity represents Discord Rich Presence activity
 type Activity struct {
 	Details    string     `json:"details,omitempty"`
@@ -33,48 +45,343 @@ type Conn interface {
 	Close() error
 }
 
-// Client handles Discord RPC connection
+// deadliner is implemented by connections whose reads and writes can time
+// out, which both unix sockets and windows pipes are
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Client handles Discord RPC connection. It is safe for concurrent use:
+// once connected, the connection belongs to a session whose goroutines do
+// all the reading and writing, and Close can be called while other calls
+// are in progress.
 type Client struct {
 	clientID string
-	conn     Conn
+	opts     Options
+	// conn is the connection during the handshake, before a session takes
+	// it over
+	conn Conn
+
+	mu      sync.Mutex
+	session *session
+	ipcPath string
+	ready   Ready
+}
+
+// Options choose which Discord the client connects to
+type Options struct {
+	// IPCPath is the only socket (or pipe on Windows) to connect to, instead
+	// of searching for one
+	IPCPath string
+	// Prefer picks among several running Discord instances: a build as
+	// Discord reports it ("stable", "canary" or "ptb") or part of the IPC
+	// path, like "vesktop". The first instance found is used if none match.
+	Prefer string
+}
+
+// User is the Discord account the client is connected as
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	// Discriminator is "0" for accounts that moved to unique usernames
+	Discriminator string `json:"discriminator"`
+	GlobalName    string `json:"global_name"`
+	// Avatar is the avatar hash, empty for the default avatar
+	Avatar string `json:"avatar"`
+}
+
+// Tag returns the name the account is known by: @username, or name#1234
+// for accounts that still have a discriminator. It is "" if Discord did not
+// say who is logged in.
+func (u User) Tag() string {
+	if u.Username == "" {
+		return ""
+	}
+	if u.Discriminator != "" && u.Discriminator != "0" {
+		return u.Username + "#" + u.Discriminator
+	}
+	return "@" + u.Username
+}
+
+// AvatarURL returns the URL of the user's avatar, or "" for the default one
+func (u User) AvatarURL() string {
+	if u.Avatar == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", u.ID, u.Avatar)
+}
+
+// Config is the client configuration Discord sends on READY
+type Config struct {
+	CDNHost     string `json:"cdn_host"`
+	APIEndpoint string `json:"api_endpoint"`
+	// Environment is "production" for every public build
+	Environment string `json:"environment"`
+}
+
+// Ready is the data of the READY event Discord sends after the handshake
+type Ready struct {
+	Version int    `json:"v"`
+	Config  Config `json:"config"`
+	User    User   `json:"user"`
+}
+
+// readyEvent is the handshake response
+type readyEvent struct {
+	Evt  string `json:"evt"`
+	Data Ready  `json:"data"`
+
+	// Code and Message explain why Discord closed the connection instead
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// build names the Discord build from its API endpoint
+func (r Ready) build() string {
+	switch endpoint := r.Config.APIEndpoint; {
+	case strings.Contains(endpoint, "canary."):
+		return "canary"
+	case strings.Contains(endpoint, "ptb."):
+		return "ptb"
+	default:
+		return "stable"
+	}
+}
+
+// IPCProbe is the result of looking for Discord at one IPC path
+type IPCProbe struct {
+	Path   string
+	Exists bool
+	// Err is the connection error for a path that exists but could not be dialled
+	Err error
+}
+
+// ProbeIPC checks every IPC path the client tries when connecting, in order,
+// or only ipcPath if it is set. It is meant for diagnostics; Connect does not
+// need it.
+func ProbeIPC(ipcPath string) []IPCProbe {
+	var probes []IPCProbe
+	for _, path := range ipcPaths(ipcPath) {
+		probes = append(probes, probeIPC(path))
+	}
+	return probes
+}
+
+// ipcPaths returns the paths to try: the explicit one, or every candidate
+func ipcPaths(ipcPath string) []string {
+	if ipcPath != "" {
+		return []string{ipcPath}
+	}
+	return ipcCandidates()
 }
 
 // NewClient creates a new Discord RPC client
 func NewClient(clientID string) *Client {
-	return &Client{clientID: clientID}
+	return NewClientWithOptions(clientID, Options{})
 }
 
-// Connect establishes connection to Discord
+// NewClientWithOptions creates a Discord RPC client that connects to a
+// specific Discord
+func NewClientWithOptions(clientID string, opts Options) *Client {
+	return &Client{clientID: clientID, opts: opts}
+}
+
+// Connect establishes connection to Discord. It can block for as long as
+// Discord takes to answer; ConnectContext bounds that.
 func (c *Client) Connect() error {
-	conn, err := c.connectToDiscord()
-	if err != nil {
-		return err
+	return c.ConnectContext(context.Background())
+}
+
+// ConnectContext establishes connection to Discord, giving up when ctx is
+// done. Sockets that refuse the connection or the handshake, like those left
+// behind by a crashed Discord, are skipped.
+func (c *Client) ConnectContext(ctx context.Context) error {
+	c.mu.Lock()
+	connected := c.session != nil && c.session.ended() == nil
+	c.mu.Unlock()
+	if connected {
+		return fmt.Errorf("already connected")
 	}
-	c.conn = conn
 
-	// Send handshake
+	var (
+		fallback *Client
+		lastErr  error
+	)
+	for _, path := range ipcPaths(c.opts.IPCPath) {
+		if err := ctx.Err(); err != nil {
+			if fallback != nil {
+				fallback.conn.Close()
+			}
+			return err
+		}
+
+		conn, err := dialIPC(ctx, path)
+		if err != nil {
+			if c.opts.IPCPath != "" || !errors.Is(err, os.ErrNotExist) {
+				lastErr = fmt.Errorf("connecting to %s: %w", path, err)
+			}
+			continue
+		}
+
+		found := &Client{clientID: c.clientID, conn: conn, ipcPath: path}
+		if err := ioContext(ctx, readWriteDeadline(conn), found.handshake); err != nil {
+			conn.Close()
+			lastErr = fmt.Errorf("%s: %w", path, err)
+			continue
+		}
+
+		if c.opts.Prefer == "" || found.matches(c.opts.Prefer) {
+			if fallback != nil {
+				fallback.conn.Close()
+			}
+			c.use(found)
+			return nil
+		}
+		if fallback == nil {
+			fallback = found
+		} else {
+			conn.Close()
+		}
+	}
+
+	if fallback != nil {
+		c.use(fallback)
+		return nil
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+	return errIPCNotFound
+}
+
+// handshake identifies the client and reads Discord's READY event
+func (c *Client) handshake() error {
 	handshake := map[string]interface{}{
 		"v":         1,
 		"client_id": c.clientID,
 	}
 	if err := c.send(opHandshake, handshake); err != nil {
-		c.conn.Close()
 		return fmt.Errorf("handshake failed: %w", err)
 	}
 
-	// Read handshake response
-	if _, err := c.receive(); err != nil {
-		c.conn.Close()
+	payload, err := c.receive()
+	if err != nil {
 		return fmt.Errorf("handshake response failed: %w", err)
 	}
-
+	var ready readyEvent
+	if err := json.Unmarshal(payload, &ready); err != nil {
+		return fmt.Errorf("handshake response failed: %w", err)
+	}
+	if ready.Evt != "READY" {
+		if ready.Message != "" {
+			return fmt.Errorf("Discord refused the handshake: %s (code %d)", ready.Message, ready.Code)
+		}
+		return fmt.Errorf("unexpected handshake response: %s", payload)
+	}
+	c.mu.Lock()
+	c.ready = ready.Data
+	c.mu.Unlock()
 	return nil
 }
 
+// matches reports whether the connected Discord is the preferred one
+func (c *Client) matches(prefer string) bool {
+	return strings.EqualFold(c.Build(), prefer) ||
+		strings.Contains(strings.ToLower(c.ipcPath), strings.ToLower(prefer))
+}
+
+// use takes over the connection of a client found by Connect
+func (c *Client) use(found *Client) {
+	c.mu.Lock()
+	c.ipcPath, c.ready = found.ipcPath, found.ready
+	c.mu.Unlock()
+	c.start(found.conn)
+}
+
+// start hands conn to a new session
+func (c *Client) start(conn Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = newSession(conn)
+}
+
+// connected returns the current session
+func (c *Client) connected() (*session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	return c.session, nil
+}
+
+// Done returns a channel that is closed when the connection ends, because
+// of Close or because Discord went away. It is nil before Connect.
+func (c *Client) Done() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		return nil
+	}
+	return c.session.done
+}
+
+// Err returns why the connection ended: ErrClosed after Close, or the
+// error that ended it. It is nil while connected.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session == nil {
+		return nil
+	}
+	return c.session.ended()
+}
+
+// IPCPath returns the socket or pipe the client is connected to
+func (c *Client) IPCPath() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ipcPath
+}
+
+// Build returns the connected Discord's build: "stable", "canary" or "ptb",
+// or "" before Connect
+func (c *Client) Build() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ready == (Ready{}) {
+		return ""
+	}
+	return c.ready.build()
+}
+
+// User returns the account Discord is logged in as, from the READY event.
+// It is the zero User before Connect.
+func (c *Client) User() User {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready.User
+}
+
+// Config returns the client configuration from the READY event
+func (c *Client) Config() Config {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ready.Config
+}
+
 // SetActivity updates the Discord Rich Presence
 func (c *Client) SetActivity(activity Activity) error {
-	if c.conn == nil {
-		return fmt.Errorf("not connected")
+	return c.SetActivityContext(context.Background(), activity)
+}
+
+// SetActivityContext updates the Discord Rich Presence and waits for
+// Discord to accept it, giving up when ctx is done
+func (c *Client) SetActivityContext(ctx context.Context, activity Activity) error {
+	s, err := c.connected()
+	if err != nil {
+		return err
 	}
 
 	// Build timestamps if StartTime is set
@@ -114,53 +421,91 @@ func (c *Client) SetActivity(activity Activity) error {
 		activityData["timestamps"] = timestamps
 	}
 
-	payload := map[string]interface{}{
-		"cmd": "SET_ACTIVITY",
-		"args": map[string]interface{}{
-			"pid":      os.Getpid(),
-			"activity": activityData,
-		},
-		"nonce": fmt.Sprintf("%d", time.Now().UnixNano()),
+	_, err = s.call(ctx, "SET_ACTIVITY", "", map[string]interface{}{
+		"pid":      os.Getpid(),
+		"activity": activityData,
+	})
+	return err
+}
+
+// ClearActivity removes the Rich Presence without disconnecting
+func (c *Client) ClearActivity() error {
+	return c.ClearActivityContext(context.Background())
+}
+
+// ClearActivityContext removes the Rich Presence without disconnecting,
+// giving up when ctx is done
+func (c *Client) ClearActivityContext(ctx context.Context) error {
+	s, err := c.connected()
+	if err != nil {
+		return err
 	}
 
-	return c.send(opFrame, payload)
+	_, err = s.call(ctx, "SET_ACTIVITY", "", map[string]interface{}{
+		"pid": os.Getpid(),
+	})
+	return err
 }
 
-// Close disconnects from Discord
+// Close disconnects from Discord. Calls in progress fail with ErrClosed, and
+// Close waits for the connection's goroutines to exit.
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
+	c.mu.Lock()
+	s, conn := c.session, c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	if s != nil {
+		s.close()
+	}
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
+// send writes a frame during the handshake
 func (c *Client) send(opcode int, data interface{}) error {
 	payload, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	return writeFrame(c.conn, opcode, payload)
+}
+
+// receive reads a frame during the handshake
+func (c *Client) receive() ([]byte, error) {
+	_, payload, err := readFrame(c.conn)
+	return payload, err
+}
 
+func writeFrame(conn Conn, opcode int, payload []byte) error {
 	// Discord IPC frame: [opcode:4bytes][length:4bytes][payload]
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, int32(opcode))
 	binary.Write(buf, binary.LittleEndian, int32(len(payload)))
 	buf.Write(payload)
 
-	_, err = c.conn.Write(buf.Bytes())
+	_, err := conn.Write(buf.Bytes())
 	return err
 }
 
-func (c *Client) receive() ([]byte, error) {
+func readFrame(conn Conn) (int, []byte, error) {
+	// A frame can arrive in several reads, so read until it is complete
 	header := make([]byte, 8)
-	if _, err := c.conn.Read(header); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return 0, nil, err
 	}
 
+	opcode := int(binary.LittleEndian.Uint32(header[0:4]))
 	length := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", length)
+	}
 	payload := make([]byte, length)
-	if _, err := c.conn.Read(payload); err != nil {
-		return nil, err
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return 0, nil, err
 	}
 
-	return payload, nil
+	return opcode, payload, nil
 }
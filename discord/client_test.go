@@ -2,10 +2,15 @@ package discord
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -45,6 +50,48 @@ func (m *mockConn) writeFrame(opcode int32, payload []byte) {
 	m.readBuffer.Write(payload)
 }
 
+// fakeSession connects client to a fake Discord on the other end of a
+// net.Pipe. The fake hands every frame it reads to the test, whole, and
+// answers it with reply, or not at all if reply returns "".
+func fakeSession(t *testing.T, client *Client, reply func(nonce string) string) <-chan []byte {
+	t.Helper()
+	local, remote := net.Pipe()
+	frames := make(chan []byte, 256)
+	go func() {
+		defer remote.Close()
+		for {
+			header := make([]byte, 8)
+			if _, err := io.ReadFull(remote, header); err != nil {
+				return
+			}
+			payload := make([]byte, binary.LittleEndian.Uint32(header[4:]))
+			if _, err := io.ReadFull(remote, payload); err != nil {
+				return
+			}
+			frames <- append(header, payload...)
+
+			var msg struct{ Nonce string }
+			json.Unmarshal(payload, &msg)
+			if response := reply(msg.Nonce); response != "" {
+				writeFrame(remote, opFrame, []byte(response))
+			}
+		}
+	}()
+	client.start(local)
+	t.Cleanup(func() { client.Close() })
+	return frames
+}
+
+// acknowledge answers a command the way Discord does
+func acknowledge(nonce string) string {
+	return `{"cmd":"SET_ACTIVITY","evt":null,"nonce":"` + nonce + `","data":{}}`
+}
+
+// silent never answers
+func silent(string) string {
+	return ""
+}
+
 func TestNewClient(t *testing.T) {
 	clientID := "123456789"
 	client := NewClient(clientID)
@@ -161,8 +208,7 @@ func TestClient_SetActivity(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			client := NewClient("test-client-id")
-			mock := &mockConn{}
-			client.conn = mock
+			frames := fakeSession(t, client, acknowledge)
 
 			err := client.SetActivity(tt.activity)
 			if err != nil {
@@ -170,7 +216,7 @@ func TestClient_SetActivity(t *testing.T) {
 			}
 
 			// Parse the written frame
-			frame := mock.writeBuffer.Bytes()
+			frame := <-frames
 			if len(frame) < 8 {
 				t.Fatalf("Frame too short: %d bytes", len(frame))
 			}
@@ -258,6 +304,39 @@ func TestClient_SetActivity(t *testing.T) {
 	}
 }
 
+func TestClient_ClearActivity(t *testing.T) {
+	client := NewClient("test-client-id")
+	frames := fakeSession(t, client, acknowledge)
+
+	if err := client.ClearActivity(); err != nil {
+		t.Fatalf("ClearActivity returned error: %v", err)
+	}
+
+	frame := <-frames
+	if len(frame) < 8 {
+		t.Fatalf("Frame too short: %d bytes", len(frame))
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(frame[8:], &msg); err != nil {
+		t.Fatalf("Failed to parse payload JSON: %v", err)
+	}
+	if msg["cmd"] != "SET_ACTIVITY" {
+		t.Errorf("cmd = %v, want SET_ACTIVITY", msg["cmd"])
+	}
+
+	args, ok := msg["args"].(map[string]interface{})
+	if !ok {
+		t.Fatal("args is not a map")
+	}
+	if _, ok := args["activity"]; ok {
+		t.Error("activity should be omitted to clear the presence")
+	}
+	if args["pid"] == nil {
+		t.Error("pid should not be nil")
+	}
+}
+
 func TestClient_send(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -396,6 +475,408 @@ func TestClient_receive(t *testing.T) {
 			t.Error("Expected error when payload read fails")
 		}
 	})
+
+	t.Run("Frame split across reads", func(t *testing.T) {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		client := NewClient("test")
+		client.conn = local
+
+		payload := []byte(`{"evt":"READY"}`)
+		go func() {
+			// net.Pipe delivers each write separately
+			binary.Write(remote, binary.LittleEndian, int32(opFrame))
+			binary.Write(remote, binary.LittleEndian, int32(len(payload)))
+			remote.Write(payload[:5])
+			remote.Write(payload[5:])
+		}()
+
+		received, err := client.receive()
+		if err != nil {
+			t.Fatalf("receive() error: %v", err)
+		}
+		if !bytes.Equal(received, payload) {
+			t.Errorf("received = %s, want %s", received, payload)
+		}
+	})
+
+	t.Run("Frame too large", func(t *testing.T) {
+		client := NewClient("test")
+		mock := &mockConn{}
+		client.conn = mock
+
+		binary.Write(&mock.readBuffer, binary.LittleEndian, int32(opFrame))
+		binary.Write(&mock.readBuffer, binary.LittleEndian, int32(maxFrameSize+1))
+
+		if _, err := client.receive(); err == nil {
+			t.Error("Expected error for an oversized frame")
+		}
+	})
+}
+
+func TestClient_handshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		opcode    int32
+		response  string
+		wantUser  User
+		wantBuild string
+		wantErr   string
+	}{
+		{
+			name:      "Ready",
+			opcode:    opFrame,
+			response:  `{"cmd":"DISPATCH","evt":"READY","data":{"v":1,"config":{"cdn_host":"cdn.discordapp.com","api_endpoint":"//discord.com/api","environment":"production"},"user":{"id":"42","username":"tester","discriminator":"0","global_name":"Tester","avatar":"abc"}}}`,
+			wantUser:  User{ID: "42", Username: "tester", Discriminator: "0", GlobalName: "Tester", Avatar: "abc"},
+			wantBuild: "stable",
+		},
+		{
+			name:      "Canary",
+			opcode:    opFrame,
+			response:  `{"cmd":"DISPATCH","evt":"READY","data":{"v":1,"config":{"api_endpoint":"//canary.discord.com/api"},"user":{"id":"1","username":"c"}}}`,
+			wantUser:  User{ID: "1", Username: "c"},
+			wantBuild: "canary",
+		},
+		{
+			name:     "Closed",
+			opcode:   2,
+			response: `{"code":4000,"message":"Invalid Client ID"}`,
+			wantErr:  "Discord refused the handshake: Invalid Client ID (code 4000)",
+		},
+		{
+			name:     "Unexpected event",
+			opcode:   opFrame,
+			response: `{"cmd":"DISPATCH","evt":"ERROR"}`,
+			wantErr:  "unexpected handshake response",
+		},
+		{
+			name:     "Invalid JSON",
+			opcode:   opFrame,
+			response: `not json`,
+			wantErr:  "handshake response failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("test")
+			mock := &mockConn{}
+			client.conn = mock
+			mock.writeFrame(tt.opcode, []byte(tt.response))
+
+			err := client.handshake()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("handshake() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("handshake() error: %v", err)
+			}
+			if client.User() != tt.wantUser {
+				t.Errorf("User() = %+v, want %+v", client.User(), tt.wantUser)
+			}
+			if client.Build() != tt.wantBuild {
+				t.Errorf("Build() = %q, want %q", client.Build(), tt.wantBuild)
+			}
+		})
+	}
+}
+
+func TestUser(t *testing.T) {
+	tests := []struct {
+		user       User
+		wantTag    string
+		wantAvatar string
+	}{
+		{User{ID: "42", Username: "tester", Discriminator: "0", Avatar: "abc"}, "@tester", "https://cdn.discordapp.com/avatars/42/abc.png"},
+		{User{ID: "7", Username: "Old", Discriminator: "1234"}, "Old#1234", ""},
+		{User{Username: "arrpc"}, "@arrpc", ""},
+		{User{}, "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.user.Tag(); got != tt.wantTag {
+			t.Errorf("%+v Tag() = %q, want %q", tt.user, got, tt.wantTag)
+		}
+		if got := tt.user.AvatarURL(); got != tt.wantAvatar {
+			t.Errorf("%+v AvatarURL() = %q, want %q", tt.user, got, tt.wantAvatar)
+		}
+	}
+}
+
+func TestClient_SetActivityContext(t *testing.T) {
+	t.Run("Blocked write is interrupted", func(t *testing.T) {
+		// Nobody reads the other end of a net.Pipe, so writes block
+		local, remote := net.Pipe()
+		defer remote.Close()
+		client := NewClient("test")
+		client.start(local)
+		defer client.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		err := client.SetActivityContext(ctx, Activity{Details: "Coding"})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("SetActivityContext() error = %v, want context.Canceled", err)
+		}
+		if client.Err() == nil {
+			t.Error("connection should be dropped after an interrupted write")
+		}
+		if err := client.SetActivity(Activity{Details: "Coding"}); err == nil {
+			t.Error("SetActivity() after an interrupted write should fail")
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		client := NewClient("test")
+		client.start(local)
+		defer client.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if err := client.ClearActivityContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("ClearActivityContext() error = %v, want context.DeadlineExceeded", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("ClearActivityContext() took %v, want about 50ms", elapsed)
+		}
+	})
+
+	t.Run("Deadline is reset after success", func(t *testing.T) {
+		client := NewClient("test")
+		fakeSession(t, client, acknowledge)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if err := client.SetActivityContext(ctx, Activity{Details: "Coding"}); err != nil {
+			t.Fatalf("SetActivityContext() error: %v", err)
+		}
+		cancel()
+		time.Sleep(60 * time.Millisecond)
+		if err := client.SetActivity(Activity{Details: "Coding"}); err != nil {
+			t.Errorf("SetActivity() after an earlier deadline passed: %v", err)
+		}
+	})
+
+	t.Run("Already cancelled", func(t *testing.T) {
+		client := NewClient("test")
+		frames := fakeSession(t, client, acknowledge)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := client.SetActivityContext(ctx, Activity{Details: "Coding"}); !errors.Is(err, context.Canceled) {
+			t.Errorf("SetActivityContext() error = %v, want context.Canceled", err)
+		}
+		client.Close()
+		if len(frames) != 0 {
+			t.Error("nothing should be sent with a cancelled context")
+		}
+	})
+}
+
+// TestClient_Concurrent tests calls from many goroutines sharing a client,
+// meant to be run with the race detector
+func TestClient_Concurrent(t *testing.T) {
+	client := NewClient("test")
+	client.ready = Ready{User: User{Username: "tester"}}
+	frames := fakeSession(t, client, acknowledge)
+
+	const workers, calls = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*calls)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range calls {
+				var err error
+				if i%2 == 0 {
+					err = client.SetActivity(Activity{Details: fmt.Sprintf("worker %d call %d", w, i)})
+				} else {
+					err = client.ClearActivity()
+				}
+				if err != nil {
+					errs <- err
+				}
+				client.User()
+				client.Build()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("concurrent call failed: %v", err)
+	}
+
+	// Every frame arrived whole, each with its own nonce
+	nonces := map[string]bool{}
+	for range workers * calls {
+		frame := <-frames
+		var msg struct{ Nonce string }
+		if err := json.Unmarshal(frame[8:], &msg); err != nil {
+			t.Fatalf("interleaved frame %q: %v", frame, err)
+		}
+		if nonces[msg.Nonce] {
+			t.Errorf("nonce %q used twice", msg.Nonce)
+		}
+		nonces[msg.Nonce] = true
+	}
+}
+
+// TestClient_CloseConcurrent tests closing a client while calls wait for
+// Discord to respond
+func TestClient_CloseConcurrent(t *testing.T) {
+	client := NewClient("test")
+	frames := fakeSession(t, client, silent)
+
+	const callers = 4
+	errs := make(chan error, callers)
+	for range callers {
+		go func() {
+			errs <- client.SetActivity(Activity{Details: "Coding"})
+		}()
+	}
+	for range callers {
+		<-frames
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Close(); err != nil {
+				t.Errorf("Close() error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for range callers {
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("call in progress returned %v, want ErrClosed", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("call in progress did not return after Close()")
+		}
+	}
+	select {
+	case <-client.Done():
+	default:
+		t.Error("Done() should be closed after Close()")
+	}
+	if err := client.SetActivity(Activity{Details: "Coding"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("SetActivity() after Close() = %v, want ErrClosed", err)
+	}
+}
+
+// TestClient_responses tests how the reader goroutine handles what Discord
+// sends back
+func TestClient_responses(t *testing.T) {
+	t.Run("Error response", func(t *testing.T) {
+		client := NewClient("test")
+		fakeSession(t, client, func(nonce string) string {
+			return `{"cmd":"SET_ACTIVITY","evt":"ERROR","nonce":"` + nonce + `","data":{"code":4000,"message":"child \"activity\" fails"}}`
+		})
+
+		err := client.SetActivity(Activity{Details: "Coding"})
+		var discordErr *Error
+		if !errors.As(err, &discordErr) || discordErr.Code != 4000 {
+			t.Fatalf("SetActivity() error = %v, want a Discord error with code 4000", err)
+		}
+		if client.Err() != nil {
+			t.Errorf("an error response should not end the connection, got %v", client.Err())
+		}
+	})
+
+	t.Run("Responses out of order", func(t *testing.T) {
+		local, remote := net.Pipe()
+		client := NewClient("test")
+		client.start(local)
+		defer client.Close()
+
+		// Answer two commands in the opposite order they were sent
+		go func() {
+			var nonces []string
+			for range 2 {
+				_, payload, err := readFrame(remote)
+				if err != nil {
+					return
+				}
+				var msg struct{ Nonce string }
+				json.Unmarshal(payload, &msg)
+				nonces = append(nonces, msg.Nonce)
+			}
+			writeFrame(remote, opFrame, []byte(acknowledge(nonces[1])))
+			writeFrame(remote, opFrame, []byte(acknowledge(nonces[0])))
+		}()
+
+		errs := make(chan error, 2)
+		for range 2 {
+			go func() { errs <- client.ClearActivity() }()
+		}
+		for range 2 {
+			if err := <-errs; err != nil {
+				t.Errorf("ClearActivity() error: %v", err)
+			}
+		}
+	})
+
+	t.Run("Ping", func(t *testing.T) {
+		local, remote := net.Pipe()
+		client := NewClient("test")
+		client.start(local)
+		defer client.Close()
+
+		go writeFrame(remote, opPing, []byte(`{"n":1}`))
+		opcode, payload, err := readFrame(remote)
+		if err != nil {
+			t.Fatalf("reading the pong: %v", err)
+		}
+		if opcode != opPong || string(payload) != `{"n":1}` {
+			t.Errorf("got opcode %d %s, want a pong echoing the ping", opcode, payload)
+		}
+	})
+
+	t.Run("Discord closes the connection", func(t *testing.T) {
+		local, remote := net.Pipe()
+		client := NewClient("test")
+		client.start(local)
+		defer client.Close()
+
+		go writeFrame(remote, opClose, []byte(`{"code":4000,"message":"bye"}`))
+		select {
+		case <-client.Done():
+		case <-time.After(time.Second):
+			t.Fatal("Done() was not closed")
+		}
+		if err := client.Err(); err == nil || !strings.Contains(err.Error(), "bye") {
+			t.Errorf("Err() = %v, want Discord's reason", err)
+		}
+		if err := client.SetActivity(Activity{Details: "Coding"}); err == nil {
+			t.Error("SetActivity() after Discord closed the connection should fail")
+		}
+	})
+
+	t.Run("Connection lost", func(t *testing.T) {
+		local, remote := net.Pipe()
+		client := NewClient("test")
+		client.start(local)
+		defer client.Close()
+
+		remote.Close()
+		<-client.Done()
+		if err := client.Err(); err == nil || errors.Is(err, ErrClosed) {
+			t.Errorf("Err() = %v, want the read error", err)
+		}
+	})
 }
 
 func TestFrameFormat(t *testing.T) {
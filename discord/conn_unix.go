@@ -3,23 +3,25 @@
 package discord
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 )
 
-func (c *Client) connectToDiscord() (Conn, error) {
-	for i := 0; i < 10; i++ {
-		if path := findSocketPath(i); path != "" {
-			if conn, err := net.Dial("unix", path); err == nil {
-				return conn, nil
-			}
-		}
+var errIPCNotFound = errors.New("Discord IPC socket not found. Make sure Discord is running")
+
+func dialIPC(ctx context.Context, path string) (Conn, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Discord IPC socket not found. Make sure Discord is running")
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", path)
 }
 
-func findSocketPath(i int) string {
+// ipcCandidates returns every socket path to try, in order of preference
+func ipcCandidates() []string {
 	dirs := []string{
 		os.Getenv("XDG_RUNTIME_DIR"),
 		os.Getenv("TMPDIR"),
@@ -28,23 +30,46 @@ func findSocketPath(i int) string {
 		"/tmp",
 	}
 
-	// Socket path patterns: standard, snap, and flatpak
+	// Socket path patterns: standard (also used by arRPC and native
+	// Vesktop), snap, and flatpak in both the app and per-app runtime dirs
 	patterns := []string{
 		"discord-ipc-%d",
 		"snap.discord/discord-ipc-%d",
 		"app/com.discordapp.Discord/discord-ipc-%d",
+		"snap.discord-canary/discord-ipc-%d",
+		"app/com.discordapp.DiscordCanary/discord-ipc-%d",
+		"app/com.discordapp.DiscordPTB/discord-ipc-%d",
+		"app/dev.vencord.Vesktop/discord-ipc-%d",
+		".flatpak/com.discordapp.Discord/xdg-run/discord-ipc-%d",
+		".flatpak/com.discordapp.DiscordCanary/xdg-run/discord-ipc-%d",
+		".flatpak/dev.vencord.Vesktop/xdg-run/discord-ipc-%d",
 	}
 
-	for _, dir := range dirs {
-		if dir == "" {
-			continue
-		}
-		for _, pattern := range patterns {
-			path := fmt.Sprintf("%s/"+pattern, dir, i)
-			if _, err := os.Stat(path); err == nil {
-				return path
+	var paths []string
+	for i := 0; i < 10; i++ {
+		for _, dir := range dirs {
+			if dir == "" {
+				continue
+			}
+			for _, pattern := range patterns {
+				paths = append(paths, fmt.Sprintf("%s/"+pattern, dir, i))
 			}
 		}
 	}
-	return ""
+	return paths
+}
+
+func probeIPC(path string) IPCProbe {
+	probe := IPCProbe{Path: path}
+	if _, err := os.Stat(path); err != nil {
+		return probe
+	}
+	probe.Exists = true
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		probe.Err = err
+		return probe
+	}
+	conn.Close()
+	return probe
 }
@@ -0,0 +1,214 @@
+//go:build !windows
+
+package discord
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readyResponse is a handshake response from a Discord of the given build
+func readyResponse(apiEndpoint string) string {
+	return `{"cmd":"DISPATCH","evt":"READY","data":{"v":1,"config":{"api_endpoint":"` + apiEndpoint + `"}}}`
+}
+
+// fakeDiscord listens on path and answers every handshake with response,
+// sent with opcode op
+func fakeDiscord(t *testing.T, path string, op int32, response string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				header := make([]byte, 8)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				io.CopyN(io.Discard, conn, int64(binary.LittleEndian.Uint32(header[4:])))
+				binary.Write(conn, binary.LittleEndian, op)
+				binary.Write(conn, binary.LittleEndian, int32(len(response)))
+				io.WriteString(conn, response)
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+}
+
+// staleSocket leaves a socket file nobody listens on, as a crashed Discord does
+func staleSocket(t *testing.T, path string) {
+	t.Helper()
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+}
+
+// TestConnectDiscovery tests picking a Discord among several sockets
+func TestConnectDiscovery(t *testing.T) {
+	// Unix socket paths are length-limited, so keep the directory short
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, env := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+
+	stale := filepath.Join(dir, "discord-ipc-0")
+	stable := filepath.Join(dir, "snap.discord/discord-ipc-0")
+	refusing := filepath.Join(dir, "app/com.discordapp.Discord/discord-ipc-0")
+	canary := filepath.Join(dir, "app/com.discordapp.DiscordCanary/discord-ipc-0")
+	vesktop := filepath.Join(dir, "app/dev.vencord.Vesktop/discord-ipc-1")
+
+	staleSocket(t, stale)
+	fakeDiscord(t, stable, opFrame, readyResponse("//discord.com/api"))
+	fakeDiscord(t, canary, opFrame, readyResponse("//canary.discord.com/api"))
+	fakeDiscord(t, vesktop, opFrame, readyResponse("//discord.com/api"))
+	fakeDiscord(t, refusing, 2, `{"code":4000,"message":"Invalid Client ID"}`)
+
+	tests := []struct {
+		name      string
+		opts      Options
+		wantPath  string
+		wantBuild string
+		wantErr   string
+	}{
+		{"first that answers", Options{}, stable, "stable", ""},
+		{"prefer build", Options{Prefer: "canary"}, canary, "canary", ""},
+		{"prefer path", Options{Prefer: "Vesktop"}, vesktop, "stable", ""},
+		{"no match falls back", Options{Prefer: "ptb"}, stable, "stable", ""},
+		{"explicit path", Options{IPCPath: canary}, canary, "canary", ""},
+		{"explicit stale path", Options{IPCPath: stale}, "", "", "connection refused"},
+		{"explicit missing path", Options{IPCPath: filepath.Join(dir, "missing")}, "", "", "no such file"},
+		{"explicit refused handshake", Options{IPCPath: refusing}, "", "", "Invalid Client ID (code 4000)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClientWithOptions("123", tt.opts)
+			err := client.Connect()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Connect() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Connect() error: %v", err)
+			}
+			defer client.Close()
+			if client.IPCPath() != tt.wantPath || client.Build() != tt.wantBuild {
+				t.Errorf("connected to %s (%s), want %s (%s)", client.IPCPath(), client.Build(), tt.wantPath, tt.wantBuild)
+			}
+		})
+	}
+}
+
+func TestProbeIPC(t *testing.T) {
+	// Unix socket paths are length-limited, so keep the directory short
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, env := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+
+	live := filepath.Join(dir, "discord-ipc-0")
+	ln, err := net.Listen("unix", live)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	// A socket file nobody listens on, as left behind by a crashed Discord
+	stale := filepath.Join(dir, "discord-ipc-1")
+	staleLn, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	staleLn.(*net.UnixListener).SetUnlinkOnClose(false)
+	staleLn.Close()
+
+	probes := map[string]IPCProbe{}
+	for _, p := range ProbeIPC("") {
+		probes[p.Path] = p
+	}
+
+	if p := probes[live]; !p.Exists || p.Err != nil {
+		t.Errorf("live socket probe = %+v, want exists without error", p)
+	}
+	if p := probes[stale]; !p.Exists || p.Err == nil {
+		t.Errorf("stale socket probe = %+v, want exists with error", p)
+	}
+	if p, ok := probes[filepath.Join(dir, "discord-ipc-2")]; !ok || p.Exists {
+		t.Errorf("missing socket probe = %+v (tried: %v), want tried and not existing", p, ok)
+	}
+}
+
+// TestConnectContext tests giving up on a Discord that never answers
+func TestConnectContext(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ipc")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Accepts connections but never answers the handshake
+	path := filepath.Join(dir, "discord-ipc-0")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	client := NewClientWithOptions("123", Options{IPCPath: path})
+	err = client.ConnectContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ConnectContext() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ConnectContext() took %v, want about 100ms", elapsed)
+	}
+}
@@ -3,18 +3,46 @@
 package discord
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/Microsoft/go-winio"
 )
 
-func (c *Client) connectToDiscord() (Conn, error) {
+var errIPCNotFound = errors.New("Discord IPC pipe not found. Make sure Discord is running")
+
+func dialIPC(ctx context.Context, path string) (Conn, error) {
+	// A busy pipe is retried until ctx is done, so give up on it after as
+	// long as DialPipe would
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	return winio.DialPipeContext(ctx, path)
+}
+
+// ipcCandidates returns every named pipe to try, in order of preference
+func ipcCandidates() []string {
+	var paths []string
 	for i := 0; i < 10; i++ {
-		pipePath := fmt.Sprintf(`\\.\pipe\discord-ipc-%d`, i)
-		conn, err := winio.DialPipe(pipePath, nil)
-		if err == nil {
-			return conn, nil
+		paths = append(paths, fmt.Sprintf(`\\.\pipe\discord-ipc-%d`, i))
+	}
+	return paths
+}
+
+func probeIPC(path string) IPCProbe {
+	probe := IPCProbe{Path: path}
+	timeout := time.Second
+	conn, err := winio.DialPipe(path, &timeout)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			probe.Exists = true
+			probe.Err = err
 		}
+		return probe
 	}
-	return nil, fmt.Errorf("Discord IPC pipe not found. Make sure Discord is running")
+	probe.Exists = true
+	conn.Close()
+	return probe
 }
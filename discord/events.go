@@ -0,0 +1,202 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+)
+
+// Events Discord dispatches about the activity's party, once subscribed to.
+// Joining and spectating need the activity to carry the matching secrets.
+const (
+	// EventActivityJoin is sent when the user joins a party from Discord;
+	// its data is an ActivitySecret
+	EventActivityJoin = "ACTIVITY_JOIN"
+	// EventActivitySpectate is sent when the user spectates from Discord;
+	// its data is an ActivitySecret
+	EventActivitySpectate = "ACTIVITY_SPECTATE"
+	// EventActivityJoinRequest is sent when another user asks to join; its
+	// data is an ActivityJoinRequest
+	EventActivityJoinRequest = "ACTIVITY_JOIN_REQUEST"
+)
+
+// eventBuffer is how many events a subscription holds for a reader that
+// has not caught up
+const eventBuffer = 16
+
+// Event is an event Discord dispatched to a subscription
+type Event struct {
+	// Name is the event, like EventActivityJoin
+	Name string
+	Data json.RawMessage
+}
+
+// Decode unmarshals the event's data into v
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// ActivitySecret is the data of ACTIVITY_JOIN and ACTIVITY_SPECTATE
+type ActivitySecret struct {
+	Secret string `json:"secret"`
+}
+
+// ActivityJoinRequest is the data of ACTIVITY_JOIN_REQUEST
+type ActivityJoinRequest struct {
+	User User `json:"user"`
+}
+
+// subKey identifies a subscription on Discord's side: the event and its
+// arguments as JSON
+type subKey struct {
+	evt  string
+	args string
+}
+
+// Subscription receives the events of one Subscribe call
+type Subscription struct {
+	key     subKey
+	args    interface{}
+	session *session
+	events  chan Event
+	// filter are the arguments as fields, to tell which subscription an
+	// event is for
+	filter map[string]json.RawMessage
+}
+
+// wants reports whether an event with the given data is for this
+// subscription. Discord does not say which subscription an event answers,
+// so an argument the data also has, such as channel_id, must match; one it
+// does not have cannot rule the event out.
+func (s *Subscription) wants(data map[string]json.RawMessage) bool {
+	for name, want := range s.filter {
+		got, ok := data[name]
+		if !ok {
+			continue
+		}
+		var a, b interface{}
+		if json.Unmarshal(want, &a) != nil || json.Unmarshal(got, &b) != nil || !reflect.DeepEqual(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// Events returns the channel events are delivered on. It is closed by
+// Unsubscribe or when the connection ends. Events arriving while the
+// channel is full are dropped, so read it promptly.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Unsubscribe stops the subscription and closes its channel
+func (s *Subscription) Unsubscribe() error {
+	return s.UnsubscribeContext(context.Background())
+}
+
+// UnsubscribeContext stops the subscription and closes its channel, giving
+// up on telling Discord when ctx is done. Discord is only told once no
+// other subscription wants the event with the same arguments.
+func (s *Subscription) UnsubscribeContext(ctx context.Context) error {
+	if !s.session.unsubscribe(s) {
+		return nil
+	}
+	_, err := s.session.call(ctx, "UNSUBSCRIBE", s.key.evt, s.args)
+	return err
+}
+
+// Subscribe asks Discord to dispatch evt, such as EventActivityJoin, to the
+// returned subscription. args are the event's arguments, nil for none.
+func (c *Client) Subscribe(evt string, args interface{}) (*Subscription, error) {
+	return c.SubscribeContext(context.Background(), evt, args)
+}
+
+// SubscribeContext asks Discord to dispatch evt to the returned
+// subscription, giving up when ctx is done
+func (c *Client) SubscribeContext(ctx context.Context, evt string, args interface{}) (*Subscription, error) {
+	s, err := c.connected()
+	if err != nil {
+		return nil, err
+	}
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	argsJSON, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Registered first, so events dispatched right after the response are
+	// not missed
+	sub := &Subscription{
+		key:     subKey{evt: evt, args: string(argsJSON)},
+		args:    args,
+		session: s,
+		events:  make(chan Event, eventBuffer),
+	}
+	// Arguments that are not an object do not filter anything
+	json.Unmarshal(argsJSON, &sub.filter)
+	if err := s.subscribe(sub); err != nil {
+		return nil, err
+	}
+	if _, err := s.call(ctx, "SUBSCRIBE", evt, args); err != nil {
+		s.unsubscribe(sub)
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Call sends any RPC command and returns the data of Discord's response,
+// for commands the client has no method for
+func (c *Client) Call(ctx context.Context, cmd string, args interface{}) (json.RawMessage, error) {
+	s, err := c.connected()
+	if err != nil {
+		return nil, err
+	}
+	return s.call(ctx, cmd, "", args)
+}
+
+// SendJoinInvite accepts a user's ACTIVITY_JOIN_REQUEST
+func (c *Client) SendJoinInvite(ctx context.Context, userID string) error {
+	_, err := c.Call(ctx, "SEND_ACTIVITY_JOIN_INVITE", map[string]string{"user_id": userID})
+	return err
+}
+
+// CloseJoinRequest rejects a user's ACTIVITY_JOIN_REQUEST
+func (c *Client) CloseJoinRequest(ctx context.Context, userID string) error {
+	_, err := c.Call(ctx, "CLOSE_ACTIVITY_REQUEST", map[string]string{"user_id": userID})
+	return err
+}
+
+// subscribe adds sub to the subscriptions events are dispatched to
+func (s *session) subscribe(sub *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		return s.ended()
+	}
+	s.subs[sub.key] = append(s.subs[sub.key], sub)
+	return nil
+}
+
+// unsubscribe removes sub and closes its channel. It reports whether sub
+// was the last subscription to its event with its arguments, so Discord can
+// stop sending it.
+func (s *session) unsubscribe(sub *Subscription) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := s.subs[sub.key]
+	for i, other := range subs {
+		if other == sub {
+			close(sub.events)
+			subs = append(subs[:i:i], subs[i+1:]...)
+			if len(subs) == 0 {
+				delete(s.subs, sub.key)
+				return true
+			}
+			s.subs[sub.key] = subs
+			return false
+		}
+	}
+	return false
+}
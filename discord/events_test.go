@@ -0,0 +1,282 @@
+package discord
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// command is a command the fake Discord in these tests read
+type command struct {
+	Cmd   string                 `json:"cmd"`
+	Evt   string                 `json:"evt"`
+	Nonce string                 `json:"nonce"`
+	Args  map[string]interface{} `json:"args"`
+}
+
+// scriptedSession connects client to a fake Discord the test drives: it
+// reads commands from the returned channel and writes frames to remote
+func scriptedSession(t *testing.T, client *Client) (<-chan command, net.Conn) {
+	t.Helper()
+	local, remote := net.Pipe()
+	commands := make(chan command, 16)
+	go func() {
+		defer close(commands)
+		for {
+			_, payload, err := readFrame(remote)
+			if err != nil {
+				return
+			}
+			var cmd command
+			json.Unmarshal(payload, &cmd)
+			commands <- cmd
+		}
+	}()
+	client.start(local)
+	t.Cleanup(func() { client.Close() })
+	return commands, remote
+}
+
+// reply answers cmd the way Discord does, with evt "ERROR" for errors. It
+// is also called from the fake's goroutines, so it does not stop the test.
+func reply(t *testing.T, remote net.Conn, cmd command, evt, data string) {
+	t.Helper()
+	response, _ := json.Marshal(map[string]interface{}{
+		"cmd":   cmd.Cmd,
+		"evt":   evt,
+		"nonce": cmd.Nonce,
+		"data":  json.RawMessage(data),
+	})
+	if err := writeFrame(remote, opFrame, response); err != nil {
+		t.Errorf("replying to %s: %v", cmd.Cmd, err)
+	}
+}
+
+// dispatchEvent sends an event the way Discord does
+func dispatchEvent(t *testing.T, remote net.Conn, evt, data string) {
+	t.Helper()
+	if err := writeFrame(remote, opFrame, []byte(`{"cmd":"DISPATCH","evt":"`+evt+`","data":`+data+`}`)); err != nil {
+		t.Fatalf("dispatching %s: %v", evt, err)
+	}
+}
+
+// subscribe subscribes to evt without arguments, answering the SUBSCRIBE
+// command
+func subscribe(t *testing.T, client *Client, commands <-chan command, remote net.Conn, evt string) *Subscription {
+	t.Helper()
+	return subscribeArgs(t, client, commands, remote, evt, map[string]interface{}{})
+}
+
+// subscribeArgs subscribes to evt with args, answering the SUBSCRIBE command
+func subscribeArgs(t *testing.T, client *Client, commands <-chan command, remote net.Conn, evt string, args map[string]interface{}) *Subscription {
+	t.Helper()
+	type result struct {
+		sub *Subscription
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sub, err := client.Subscribe(evt, args)
+		done <- result{sub, err}
+	}()
+
+	cmd := <-commands
+	if cmd.Cmd != "SUBSCRIBE" || cmd.Evt != evt || !reflect.DeepEqual(cmd.Args, args) {
+		t.Fatalf("got %+v, want SUBSCRIBE to %s with args %v", cmd, evt, args)
+	}
+	reply(t, remote, cmd, "", `{"evt":"`+evt+`"}`)
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("Subscribe(%s) error: %v", evt, r.err)
+	}
+	return r.sub
+}
+
+// nextEvent returns the next event on sub, failing the test if none comes
+func nextEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e, ok := <-sub.Events():
+		if !ok {
+			t.Fatal("Events() was closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+// TestSubscribe tests receiving dispatched events
+func TestSubscribe(t *testing.T) {
+	client := NewClient("test")
+	commands, remote := scriptedSession(t, client)
+
+	join := subscribe(t, client, commands, remote, EventActivityJoin)
+	requests := subscribe(t, client, commands, remote, EventActivityJoinRequest)
+
+	// Each subscription only sees its own event
+	dispatchEvent(t, remote, EventActivityJoinRequest, `{"user":{"id":"42","username":"friend"}}`)
+	dispatchEvent(t, remote, EventActivityJoin, `{"secret":"s3cret"}`)
+
+	var secret ActivitySecret
+	if e := nextEvent(t, join); e.Name != EventActivityJoin {
+		t.Errorf("join subscription got %s", e.Name)
+	} else if err := e.Decode(&secret); err != nil || secret.Secret != "s3cret" {
+		t.Errorf("Decode() = %+v, %v, want the secret", secret, err)
+	}
+
+	var request ActivityJoinRequest
+	if err := nextEvent(t, requests).Decode(&request); err != nil || request.User.Tag() != "@friend" {
+		t.Errorf("Decode() = %+v, %v, want the requesting user", request, err)
+	}
+	select {
+	case e := <-join.Events():
+		t.Errorf("join subscription got an extra event %+v", e)
+	default:
+	}
+}
+
+// TestSubscribe_Error tests a subscription Discord refuses
+func TestSubscribe_Error(t *testing.T) {
+	client := NewClient("test")
+	commands, remote := scriptedSession(t, client)
+
+	go func() {
+		cmd := <-commands
+		reply(t, remote, cmd, "ERROR", `{"code":4006,"message":"Not authenticated or invalid scope"}`)
+	}()
+	sub, err := client.Subscribe(EventActivityJoin, nil)
+	var discordErr *Error
+	if !errors.As(err, &discordErr) || discordErr.Code != 4006 {
+		t.Fatalf("Subscribe() = %v, %v, want a Discord error with code 4006", sub, err)
+	}
+
+	// Nothing is left subscribed
+	client.session.mu.Lock()
+	defer client.session.mu.Unlock()
+	if subs := client.session.subs; len(subs) != 0 {
+		t.Errorf("subscriptions after a refused Subscribe() = %v, want none", subs)
+	}
+}
+
+// TestSubscription_Unsubscribe tests that Discord is told once the last
+// subscription to an event stops
+func TestSubscription_Unsubscribe(t *testing.T) {
+	client := NewClient("test")
+	commands, remote := scriptedSession(t, client)
+
+	first := subscribe(t, client, commands, remote, EventActivitySpectate)
+	second := subscribe(t, client, commands, remote, EventActivitySpectate)
+
+	dispatchEvent(t, remote, EventActivitySpectate, `{"secret":"s"}`)
+	nextEvent(t, first)
+	nextEvent(t, second)
+
+	if err := first.Unsubscribe(); err != nil {
+		t.Fatalf("Unsubscribe() error: %v", err)
+	}
+	if _, ok := <-first.Events(); ok {
+		t.Error("Events() should be closed after Unsubscribe()")
+	}
+	select {
+	case cmd := <-commands:
+		t.Fatalf("got %s while another subscription still wants the event", cmd.Cmd)
+	default:
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- second.Unsubscribe() }()
+	cmd := <-commands
+	if cmd.Cmd != "UNSUBSCRIBE" || cmd.Evt != EventActivitySpectate {
+		t.Fatalf("got %+v, want UNSUBSCRIBE from %s", cmd, EventActivitySpectate)
+	}
+	reply(t, remote, cmd, "", `{}`)
+	if err := <-done; err != nil {
+		t.Errorf("Unsubscribe() error: %v", err)
+	}
+	if err := second.Unsubscribe(); err != nil {
+		t.Errorf("second Unsubscribe() error: %v", err)
+	}
+}
+
+// TestSubscription_SlowReader tests that a full subscription does not hold
+// up responses, and that its channel closes with the connection
+func TestSubscription_SlowReader(t *testing.T) {
+	client := NewClient("test")
+	commands, remote := scriptedSession(t, client)
+	sub := subscribe(t, client, commands, remote, EventActivityJoinRequest)
+
+	for range eventBuffer + 5 {
+		dispatchEvent(t, remote, EventActivityJoinRequest, `{"user":{"id":"1"}}`)
+	}
+	go func() {
+		cmd := <-commands
+		reply(t, remote, cmd, "", `{}`)
+	}()
+	if err := client.SendJoinInvite(t.Context(), "1"); err != nil {
+		t.Fatalf("SendJoinInvite() with a full subscription: %v", err)
+	}
+
+	client.Close()
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	if received != eventBuffer {
+		t.Errorf("received %d events, want the %d buffered", received, eventBuffer)
+	}
+	if _, err := client.Subscribe(EventActivityJoin, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Subscribe() after Close() = %v, want ErrClosed", err)
+	}
+}
+
+// TestSubscription_Args tests that subscriptions to the same event with
+// different arguments are kept apart
+func TestSubscription_Args(t *testing.T) {
+	client := NewClient("test")
+	commands, remote := scriptedSession(t, client)
+
+	const evt = "MESSAGE_CREATE"
+	general := subscribeArgs(t, client, commands, remote, evt, map[string]interface{}{"channel_id": "1"})
+	random := subscribeArgs(t, client, commands, remote, evt, map[string]interface{}{"channel_id": "2"})
+
+	dispatchEvent(t, remote, evt, `{"channel_id":"2","message":{"content":"hi"}}`)
+	dispatchEvent(t, remote, evt, `{"channel_id":"1","message":{"content":"hello"}}`)
+
+	var msg struct {
+		ChannelID string `json:"channel_id"`
+	}
+	if err := nextEvent(t, general).Decode(&msg); err != nil || msg.ChannelID != "1" {
+		t.Errorf("channel 1 subscription got channel %q, %v", msg.ChannelID, err)
+	}
+	if err := nextEvent(t, random).Decode(&msg); err != nil || msg.ChannelID != "2" {
+		t.Errorf("channel 2 subscription got channel %q, %v", msg.ChannelID, err)
+	}
+	select {
+	case e := <-general.Events():
+		t.Errorf("channel 1 subscription got another channel's event %s", e.Data)
+	default:
+	}
+
+	// Each subscription unsubscribes with its own arguments, even while the
+	// other still wants the event
+	for _, tt := range []struct {
+		sub     *Subscription
+		channel string
+	}{{general, "1"}, {random, "2"}} {
+		done := make(chan error, 1)
+		go func() { done <- tt.sub.Unsubscribe() }()
+		cmd := <-commands
+		if cmd.Cmd != "UNSUBSCRIBE" || cmd.Evt != evt || cmd.Args["channel_id"] != tt.channel {
+			t.Fatalf("got %+v, want UNSUBSCRIBE from channel %s", cmd, tt.channel)
+		}
+		reply(t, remote, cmd, "", `{}`)
+		if err := <-done; err != nil {
+			t.Errorf("Unsubscribe() error: %v", err)
+		}
+	}
+}
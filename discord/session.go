@@ -0,0 +1,324 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrClosed is returned by calls on a client after Close
+var ErrClosed = errors.New("client closed")
+
+// pongTimeout bounds answering a ping, so a connection that stopped taking
+// writes cannot hold up the reader
+const pongTimeout = 5 * time.Second
+
+// Error is an error Discord returned for a command
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Discord returned an error: %s (code %d)", e.Message, e.Code)
+}
+
+// request is a frame waiting for the writer goroutine
+type request struct {
+	ctx     context.Context
+	opcode  int
+	payload []byte
+	// written receives the result of writing the frame
+	written chan error
+}
+
+// response is a frame Discord sent, either in answer to a command (matched
+// by its nonce) or as an event
+type response struct {
+	Cmd   string          `json:"cmd"`
+	Evt   string          `json:"evt"`
+	Nonce string          `json:"nonce"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// session is one connection to Discord after the handshake. Its writer
+// goroutine is the only one writing to the connection and its reader
+// goroutine the only one reading, so calls from any goroutine are queued
+// as requests and matched with their responses by nonce.
+type session struct {
+	conn     Conn
+	requests chan *request
+
+	// done is closed when the connection ends; err says why
+	done    chan struct{}
+	err     error
+	endOnce sync.Once
+	loops   sync.WaitGroup
+
+	nonce   atomic.Uint64
+	mu      sync.Mutex
+	pending map[string]chan response
+	// subs are the subscriptions by event and arguments, nil once the
+	// session ended
+	subs map[subKey][]*Subscription
+}
+
+// newSession takes over conn and starts its writer and reader goroutines
+func newSession(conn Conn) *session {
+	s := &session{
+		conn:     conn,
+		requests: make(chan *request),
+		done:     make(chan struct{}),
+		pending:  map[string]chan response{},
+		subs:     map[subKey][]*Subscription{},
+	}
+	s.loops.Add(2)
+	go s.writeLoop()
+	go s.readLoop()
+	return s
+}
+
+// writeLoop writes queued frames until the session ends. A write cut off by
+// its context leaves half a frame behind, so any failed write ends it.
+func (s *session) writeLoop() {
+	defer s.loops.Done()
+	for {
+		var r *request
+		select {
+		case <-s.done:
+			return
+		case r = <-s.requests:
+		}
+
+		err := r.ctx.Err()
+		if err == nil {
+			err = ioContext(r.ctx, writeDeadline(s.conn), func() error {
+				return writeFrame(s.conn, r.opcode, r.payload)
+			})
+			if err != nil {
+				s.end(fmt.Errorf("writing to Discord: %w", err))
+			}
+		}
+		r.written <- err
+	}
+}
+
+// readLoop reads frames until the connection fails or Discord closes it
+func (s *session) readLoop() {
+	defer s.loops.Done()
+	for {
+		opcode, payload, err := readFrame(s.conn)
+		if err != nil {
+			s.end(fmt.Errorf("reading from Discord: %w", err))
+			return
+		}
+
+		switch opcode {
+		case opFrame:
+			s.dispatch(payload)
+		case opClose:
+			var reason Error
+			json.Unmarshal(payload, &reason)
+			s.end(fmt.Errorf("Discord closed the connection: %s (code %d)", reason.Message, reason.Code))
+			return
+		case opPing:
+			ctx, cancel := context.WithTimeout(context.Background(), pongTimeout)
+			s.send(ctx, opPong, payload)
+			cancel()
+		}
+	}
+}
+
+// dispatch hands a response to the call waiting for it and an event to its
+// subscriptions. Events nothing subscribed to are dropped.
+func (s *session) dispatch(payload []byte) {
+	var r response
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if waiting, ok := s.pending[r.Nonce]; ok && r.Nonce != "" {
+		delete(s.pending, r.Nonce)
+		waiting <- r
+		return
+	}
+	if r.Cmd != "DISPATCH" || r.Evt == "" {
+		return
+	}
+	var fields map[string]json.RawMessage
+	json.Unmarshal(r.Data, &fields)
+	for key, subs := range s.subs {
+		if key.evt != r.Evt {
+			continue
+		}
+		for _, sub := range subs {
+			if !sub.wants(fields) {
+				continue
+			}
+			// A subscriber that falls behind misses events rather than
+			// holding up every other response
+			select {
+			case sub.events <- Event{Name: r.Evt, Data: r.Data}:
+			default:
+			}
+		}
+	}
+}
+
+// send queues a frame and waits until it is written
+func (s *session) send(ctx context.Context, opcode int, payload []byte) error {
+	r := &request{ctx: ctx, opcode: opcode, payload: payload, written: make(chan error, 1)}
+	select {
+	case s.requests <- r:
+	case <-s.done:
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-r.written
+}
+
+// call sends a command and waits for Discord's response to it. evt names
+// the event SUBSCRIBE and UNSUBSCRIBE are about, and is empty otherwise.
+func (s *session) call(ctx context.Context, cmd, evt string, args interface{}) (json.RawMessage, error) {
+	nonce := strconv.FormatUint(s.nonce.Add(1), 10)
+	command := map[string]interface{}{
+		"cmd":   cmd,
+		"args":  args,
+		"nonce": nonce,
+	}
+	if evt != "" {
+		command["evt"] = evt
+	}
+	payload, err := json.Marshal(command)
+	if err != nil {
+		return nil, err
+	}
+
+	waiting := make(chan response, 1)
+	s.mu.Lock()
+	s.pending[nonce] = waiting
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, nonce)
+		s.mu.Unlock()
+	}()
+
+	if err := s.send(ctx, opFrame, payload); err != nil {
+		return nil, err
+	}
+	select {
+	case r := <-waiting:
+		if r.Evt == "ERROR" {
+			discordErr := &Error{}
+			json.Unmarshal(r.Data, discordErr)
+			return nil, discordErr
+		}
+		return r.Data, nil
+	case <-s.done:
+		return nil, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// end closes the connection, failing every call in progress with err and
+// closing every subscription's channel
+func (s *session) end(err error) {
+	s.endOnce.Do(func() {
+		s.err = err
+		close(s.done)
+		s.conn.Close()
+
+		s.mu.Lock()
+		for _, subs := range s.subs {
+			for _, sub := range subs {
+				close(sub.events)
+			}
+		}
+		s.subs = nil
+		s.mu.Unlock()
+	})
+}
+
+// close ends the session and waits for its goroutines to exit
+func (s *session) close() {
+	s.end(ErrClosed)
+	s.loops.Wait()
+}
+
+// ended returns why the session ended, or nil while it is connected
+func (s *session) ended() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// readWriteDeadline returns the function setting conn's read and write
+// deadline, or nil if it has none
+func readWriteDeadline(conn Conn) func(time.Time) error {
+	if d, ok := conn.(deadliner); ok {
+		return d.SetDeadline
+	}
+	return nil
+}
+
+// writeDeadline returns the function setting conn's write deadline only,
+// which leaves the reader goroutine's reads alone
+func writeDeadline(conn Conn) func(time.Time) error {
+	if d, ok := conn.(deadliner); ok {
+		return d.SetWriteDeadline
+	}
+	return nil
+}
+
+// ioContext runs fn's I/O under ctx: its deadline is set with setDeadline,
+// and cancelling it interrupts a blocked read or write. Whatever fn was
+// doing is cut off, so the caller has to close the connection if that
+// happens. Without setDeadline, fn runs unbounded.
+func ioContext(ctx context.Context, setDeadline func(time.Time) error, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if setDeadline == nil {
+		return fn()
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := setDeadline(deadline); err != nil {
+		return err
+	}
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		// A deadline in the past wakes up blocked reads and writes
+		setDeadline(time.Unix(1, 0))
+		close(interrupted)
+	})
+
+	err := fn()
+	if !stop() {
+		// Let the interruption finish so it cannot outlast the reset below
+		<-interrupted
+	}
+	if err != nil && (ctx.Err() != nil || errors.Is(err, os.ErrDeadlineExceeded)) {
+		// The connection's deadline can pass just before ctx notices
+		if ctx.Err() == nil {
+			return context.DeadlineExceeded
+		}
+		return ctx.Err()
+	}
+	setDeadline(time.Time{})
+	return err
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsanva/cc-discord-presence/discord"
+)
+
+// dataFileStaleAfter is how old the statusline data file can get before
+// doctor warns that Claude Code is no longer refreshing it
+const dataFileStaleAfter = 10 * time.Minute
+
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+)
+
+// doctorCheck is a single diagnostic result
+type doctorCheck struct {
+	name   string
+	status checkStatus
+	detail string
+	// lines are extra details printed under the result
+	lines []string
+	// hint is printed for warnings and failures and says how to fix them
+	hint string
+}
+
+func cmdDoctor(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("doctor", &common)
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	checks := []doctorCheck{
+		checkClaudeDir(),
+		checkIPCDiscovery(),
+		checkHandshake(),
+		checkStatusLineSettings(),
+		checkDataFile(),
+		checkJSONL(),
+		checkGit(),
+	}
+
+	failed, warned := 0, 0
+	for _, c := range checks {
+		mark := "✓"
+		switch c.status {
+		case checkWarn:
+			mark = "!"
+			warned++
+		case checkFail:
+			mark = "✗"
+			failed++
+		}
+		fmt.Printf("%s %s: %s\n", mark, c.name, c.detail)
+		for _, line := range c.lines {
+			fmt.Printf("    %s\n", line)
+		}
+		if c.status != checkPass && c.hint != "" {
+			fmt.Printf("    → %s\n", c.hint)
+		}
+	}
+
+	fmt.Println()
+	if failed > 0 {
+		fmt.Printf("❌ %d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	if warned > 0 {
+		fmt.Printf("⚠️  All checks passed with %d warning(s)\n", warned)
+		return 0
+	}
+	fmt.Println("✅ All checks passed")
+	return 0
+}
+
+func checkClaudeDir() doctorCheck {
+	c := doctorCheck{name: "Claude directory"}
+	info, err := os.Stat(claudeDir)
+	switch {
+	case err != nil:
+		c.status = checkFail
+		c.detail = err.Error()
+		c.hint = "Run Claude Code at least once, or pass --claude-dir"
+	case !info.IsDir():
+		c.status = checkFail
+		c.detail = claudeDir + " is not a directory"
+	default:
+		c.detail = claudeDir
+	}
+	return c
+}
+
+// checkIPCDiscovery lists every IPC path tried and what was found there
+func checkIPCDiscovery() doctorCheck {
+	c := doctorCheck{name: "Discord IPC discovery"}
+
+	// Paths only differ by their trailing instance number, so group them
+	// to keep the list readable: /run/user/1000/discord-ipc-{0-9}
+	var (
+		groups []string
+		tried  = map[string][]int{}
+		found  []discord.IPCProbe
+	)
+	for _, probe := range discord.ProbeIPC(cfg.Sinks.Discord.options().IPCPath) {
+		prefix := strings.TrimRight(probe.Path, "0123456789")
+		if _, ok := tried[prefix]; !ok {
+			groups = append(groups, prefix)
+			tried[prefix] = nil
+		}
+		var n int
+		if _, err := fmt.Sscanf(probe.Path[len(prefix):], "%d", &n); err == nil {
+			tried[prefix] = append(tried[prefix], n)
+		}
+		if probe.Exists {
+			found = append(found, probe)
+		}
+	}
+
+	for _, prefix := range groups {
+		nums := tried[prefix]
+		sort.Ints(nums)
+		// An ipc_path is listed as is
+		switch len(nums) {
+		case 0:
+			c.lines = append(c.lines, "tried "+prefix)
+			continue
+		case 1:
+			c.lines = append(c.lines, fmt.Sprintf("tried %s%d", prefix, nums[0]))
+			continue
+		}
+		c.lines = append(c.lines, fmt.Sprintf("tried %s{%d-%d}", prefix, nums[0], nums[len(nums)-1]))
+	}
+
+	usable := 0
+	for _, probe := range found {
+		if probe.Err != nil {
+			c.lines = append(c.lines, fmt.Sprintf("found %s (not accepting connections: %v)", probe.Path, probe.Err))
+			continue
+		}
+		usable++
+		c.lines = append(c.lines, fmt.Sprintf("found %s", probe.Path))
+	}
+
+	switch {
+	case usable > 0:
+		c.detail = fmt.Sprintf("%d usable socket(s)", usable)
+	case len(found) > 0:
+		c.status = checkFail
+		c.detail = "only stale sockets found"
+		c.hint = "Restart Discord; a previous instance left its socket behind"
+	case cfg.Sinks.Discord.IPCPath != "":
+		c.status = checkFail
+		c.detail = "no Discord IPC socket at ipc_path"
+		c.hint = "Check ipc_path (or --ipc-path), or leave it empty to search the usual places"
+	default:
+		c.status = checkFail
+		c.detail = "no Discord IPC socket found"
+		c.hint = "Start the Discord desktop app (the browser version has no IPC)"
+	}
+	return c
+}
+
+func checkHandshake() doctorCheck {
+	c := doctorCheck{name: "Discord handshake"}
+	client := discord.NewClientWithOptions(cfg.ClientID, cfg.Sinks.Discord.options())
+	ctx, cancel := context.WithTimeout(context.Background(), discordTimeout)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		c.status = checkFail
+		c.detail = err.Error()
+		c.hint = "Make sure Discord is running and client_id is a valid application ID"
+		return c
+	}
+	client.Close()
+	c.detail = fmt.Sprintf("connected as %s to %s Discord at %s with client ID %s", client.User().Tag(), client.Build(), client.IPCPath(), cfg.ClientID)
+	return c
+}
+
+// statusLineCommand returns the statusLine command from Claude Code's settings
+func statusLineCommand() (string, error) {
+	data, err := os.ReadFile(filepath.Join(claudeDir, "settings.json"))
+	if err != nil {
+		return "", err
+	}
+	var settings struct {
+		StatusLine struct {
+			Command string `json:"command"`
+		} `json:"statusLine"`
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return "", err
+	}
+	return settings.StatusLine.Command, nil
+}
+
+// isPresenceStatusLine reports whether a statusLine command feeds data to us,
+// either through the built-in statusline command or the legacy wrapper script
+func isPresenceStatusLine(command string) bool {
+	exe, args := splitStatusLineCommand(command)
+	if exe == "" {
+		return false
+	}
+	if strings.Contains(exe, "statusline-wrapper.sh") {
+		return true
+	}
+	if own, err := presenceStatusLineCommand(); err == nil && command == own {
+		return true
+	}
+	fields := strings.Fields(args)
+	return strings.Contains(filepath.Base(exe), "cc-discord-presence") &&
+		len(fields) > 0 && fields[0] == "statusline"
+}
+
+// expandHome expands a leading ~ the way the shell running the statusline would
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func checkStatusLineSettings() doctorCheck {
+	c := doctorCheck{
+		name: "Statusline settings",
+		hint: "See https://github.com/tsanva/cc-discord-presence#statusline-setup",
+	}
+
+	command, err := statusLineCommand()
+	switch {
+	case os.IsNotExist(err):
+		c.status = checkWarn
+		c.detail = "no settings.json, using JSONL fallback"
+		return c
+	case err != nil:
+		c.status = checkFail
+		c.detail = "cannot read settings.json: " + err.Error()
+		c.hint = "Fix the JSON syntax in " + filepath.Join(claudeDir, "settings.json")
+		return c
+	case command == "":
+		c.status = checkWarn
+		c.detail = "statusLine not configured, using JSONL fallback"
+		return c
+	case !isPresenceStatusLine(command):
+		c.status = checkWarn
+		c.detail = fmt.Sprintf("statusLine runs %q, not cc-discord-presence", command)
+		return c
+	}
+
+	exe, _ := splitStatusLineCommand(command)
+	wrapper := expandHome(exe)
+	info, err := os.Stat(wrapper)
+	if err != nil {
+		c.status = checkFail
+		c.detail = "statusLine command missing: " + wrapper
+		return c
+	}
+	if !isExecutable(info) {
+		c.status = checkFail
+		c.detail = "statusLine command is not executable: " + wrapper
+		c.hint = "Run: chmod +x " + wrapper
+		return c
+	}
+	c.detail = "statusLine runs " + command
+	return c
+}
+
+func checkDataFile() doctorCheck {
+	c := doctorCheck{name: "Statusline data"}
+	info, err := os.Stat(dataFilePath)
+	if err != nil {
+		c.status = checkWarn
+		c.detail = "no data file at " + dataFilePath
+		c.hint = "Once the statusline is configured, start a Claude Code session to create it"
+		return c
+	}
+
+	age := time.Since(info.ModTime()).Round(time.Second)
+	c.detail = fmt.Sprintf("%s (updated %s ago)", dataFilePath, age)
+	if age > dataFileStaleAfter {
+		c.status = checkWarn
+		c.hint = "Claude Code has not refreshed the statusline recently; is a session running?"
+		return c
+	}
+
+	if readStatusLineData() == nil {
+		c.status = checkFail
+		c.detail = dataFilePath + " does not contain valid statusline data"
+		c.hint = "Check that the statusline wrapper receives Claude Code's JSON on stdin"
+	}
+	return c
+}
+
+func checkJSONL() doctorCheck {
+	c := doctorCheck{name: "Session transcripts"}
+	path, _, err := findMostRecentJSONL()
+	if err != nil {
+		c.status = checkFail
+		c.detail = err.Error()
+		c.hint = "Start a Claude Code session so it writes to " + projectsDir
+		return c
+	}
+	c.detail = path
+	return c
+}
+
+func checkGit() doctorCheck {
+	c := doctorCheck{name: "Git"}
+	path, err := exec.LookPath("git")
+	if err != nil {
+		c.status = checkWarn
+		c.detail = "git not found in PATH, branch names will not be shown"
+		c.hint = "Install git or add it to PATH"
+		return c
+	}
+	c.detail = path
+	return c
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestCheckStatusLineSettings tests detection of the statusline configuration
+func TestCheckStatusLineSettings(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+
+	tmpDir := t.TempDir()
+	setClaudeDir(tmpDir)
+
+	wrapper := filepath.Join(tmpDir, "statusline-wrapper.sh")
+	if err := os.WriteFile(wrapper, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write wrapper: %v", err)
+	}
+
+	// setup quotes a binary path with spaces in it
+	binary := filepath.Join(tmpDir, "My Apps", "cc-discord-presence")
+	if err := os.MkdirAll(filepath.Dir(binary), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(binary, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write binary: %v", err)
+	}
+	quoted, _ := json.Marshal(statusLineCommandFor(binary, runtime.GOOS))
+
+	tests := []struct {
+		name     string
+		settings string
+		want     checkStatus
+	}{
+		{name: "No statusLine", settings: `{"model": "opus"}`, want: checkWarn},
+		{name: "Other statusLine", settings: `{"statusLine": {"command": "~/bin/prompt.sh"}}`, want: checkWarn},
+		{name: "Wrapper configured", settings: `{"statusLine": {"command": "` + wrapper + `", "type": "command"}}`, want: checkPass},
+		{name: "Quoted path with spaces", settings: `{"statusLine": {"command": ` + string(quoted) + `}}`, want: checkPass},
+		{name: "Wrapper missing", settings: `{"statusLine": {"command": "/nonexistent/statusline-wrapper.sh"}}`, want: checkFail},
+		{name: "Invalid JSON", settings: `{"statusLine":`, want: checkFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(tmpDir, "settings.json"), []byte(tt.settings), 0644); err != nil {
+				t.Fatalf("Failed to write settings: %v", err)
+			}
+			if got := checkStatusLineSettings(); got.status != tt.want {
+				t.Errorf("status = %v (%s), want %v", got.status, got.detail, tt.want)
+			}
+		})
+	}
+}
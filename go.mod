@@ -5,6 +5,6 @@ go 1.25
 require (
 	github.com/Microsoft/go-winio v0.6.2
 	github.com/fsnotify/fsnotify v1.9.0
+	github.com/godbus/dbus/v5 v5.2.2
+	golang.org/x/sys v0.27.0
 )
-
-require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ModelUsage is the usage of a single model within a session
+type ModelUsage struct {
+	Model        string  `json:"model"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// HistoryRecord is one line of the session history. A session is appended
+// again every time its usage changes, so the last record for a session ID is
+// the current one.
+type HistoryRecord struct {
+	SessionID   string       `json:"session_id"`
+	Project     string       `json:"project"`
+	ProjectPath string       `json:"project_path,omitempty"`
+	GitBranch   string       `json:"git_branch,omitempty"`
+	Model       string       `json:"model"`
+	Models      []ModelUsage `json:"models,omitempty"`
+	TotalTokens int64        `json:"total_tokens"`
+	TotalCost   float64      `json:"total_cost"`
+	DataSource  string       `json:"data_source"`
+	StartedAt   time.Time    `json:"started_at"`
+	// UpdatedAt is the last time the session's usage changed
+	UpdatedAt time.Time `json:"updated_at"`
+	// EndedAt is set once the session is over, to its last activity
+	EndedAt *time.Time `json:"ended_at,omitempty"`
+}
+
+func historyFilePath() string {
+	return filepath.Join(claudeDir, "discord-presence-history.jsonl")
+}
+
+// sessionHistory records the session the daemon is showing. It is used under
+// daemonMu.
+type sessionHistory struct {
+	current *HistoryRecord
+
+	// transcript is the current session's transcript, read incrementally
+	// into usage for its per-model numbers
+	transcript string
+	usage      *transcriptUsage
+}
+
+var history sessionHistory
+
+// record appends the session to the history if its usage changed, ending
+// the previous session when Claude Code moved on to another one
+func (h *sessionHistory) record(session *SessionData, source string, now time.Time) {
+	if session.SessionID == "" {
+		return
+	}
+	if h.current != nil && h.current.SessionID != session.SessionID {
+		h.end()
+	}
+	if h.current == nil {
+		h.transcript, h.usage = "", nil
+	}
+
+	rec := HistoryRecord{
+		SessionID:   session.SessionID,
+		Project:     session.ProjectName,
+		ProjectPath: session.ProjectPath,
+		GitBranch:   session.GitBranch,
+		Model:       session.ModelName,
+		Models:      session.Models,
+		TotalTokens: session.TotalTokens,
+		TotalCost:   session.TotalCost,
+		DataSource:  source,
+		StartedAt:   session.SessionStart,
+		UpdatedAt:   now,
+	}
+
+	if prev := h.current; prev != nil {
+		if prev.TotalTokens == rec.TotalTokens && prev.TotalCost == rec.TotalCost &&
+			prev.Model == rec.Model && prev.GitBranch == rec.GitBranch {
+			return
+		}
+		// The statusline only reports a duration, so keep the first start
+		if rec.StartedAt.IsZero() || prev.StartedAt.Before(rec.StartedAt) {
+			rec.StartedAt = prev.StartedAt
+		}
+	}
+	if rec.StartedAt.IsZero() {
+		rec.StartedAt = now
+	}
+
+	// The statusline has no per-model usage, but the session's transcript does
+	if len(rec.Models) == 0 {
+		rec.Models = h.transcriptModels(session.SessionID)
+	}
+
+	if err := appendHistory(rec); err != nil {
+		slog.Error("Failed to write session history", "err", err)
+	}
+	h.current = &rec
+}
+
+// transcriptModels returns the per-model usage in a session's transcript,
+// reading only what was appended since the last record
+func (h *sessionHistory) transcriptModels(sessionID string) []ModelUsage {
+	if h.transcript == "" {
+		if h.transcript = findTranscript(sessionID); h.transcript == "" {
+			return nil
+		}
+	}
+	info, err := os.Stat(h.transcript)
+	if err != nil {
+		h.transcript, h.usage = "", nil
+		return nil
+	}
+	// A transcript that shrank was rewritten, so read it again
+	if h.usage == nil || info.Size() < h.usage.offset {
+		h.usage = &transcriptUsage{}
+	}
+	if err := h.usage.readFrom(h.transcript); err != nil {
+		slog.Debug("Cannot read transcript", "path", h.transcript, "err", err)
+	}
+
+	// Claude Code repeats a response's usage on each of its content blocks,
+	// so count every request once, as report does
+	var entries []usageEntry
+	seen := map[string]bool{}
+	for _, e := range h.usage.entries {
+		if e.key != "" {
+			if seen[e.key] {
+				continue
+			}
+			seen[e.key] = true
+		}
+		entries = append(entries, e)
+	}
+
+	var models []ModelUsage
+	for _, row := range groupUsage(entries, func(e usageEntry) string { return e.Model }, true) {
+		models = append(models, ModelUsage{
+			Model:        row.Key,
+			InputTokens:  row.InputTokens,
+			OutputTokens: row.OutputTokens,
+			Cost:         row.Cost,
+		})
+	}
+	return models
+}
+
+// end marks the current session as finished
+func (h *sessionHistory) end() {
+	if h.current == nil {
+		return
+	}
+	rec := *h.current
+	ended := rec.UpdatedAt
+	rec.EndedAt = &ended
+	if err := appendHistory(rec); err != nil {
+		slog.Error("Failed to write session history", "err", err)
+	}
+	h.current = nil
+}
+
+// findTranscript returns the transcript of a session, or "" if there is none
+func findTranscript(sessionID string) string {
+	matches, _ := filepath.Glob(filepath.Join(projectsDir, "*", sessionID+".jsonl"))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
+
+// appendHistory appends a record and syncs it to disk, so a crash loses at
+// most the line being written
+func appendHistory(rec HistoryRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(historyFilePath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// A line cut short by a crash must not swallow the next record
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+
+	if _, err := f.Write(line); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readHistory returns the latest record of every session in the order the
+// sessions first appeared, skipping lines that cannot be parsed. lines is
+// the number of records in the file.
+func readHistory(r io.Reader) (records []HistoryRecord, lines int, err error) {
+	index := map[string]int{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var rec HistoryRecord
+		if err := json.Unmarshal(line, &rec); err != nil || rec.SessionID == "" {
+			continue
+		}
+		lines++
+		if i, ok := index[rec.SessionID]; ok {
+			records[i] = rec
+			continue
+		}
+		index[rec.SessionID] = len(records)
+		records = append(records, rec)
+	}
+	return records, lines, scanner.Err()
+}
+
+// loadHistory reads the history file; a missing file is an empty history
+func loadHistory() ([]HistoryRecord, int, error) {
+	f, err := os.Open(historyFilePath())
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	return readHistory(f)
+}
+
+// compactHistory rewrites the history with only the latest record of each
+// session. Sessions that were still running when a daemon stopped without
+// ending them are left open; the next update for them continues the record.
+func compactHistory() error {
+	records, lines, err := loadHistory()
+	if err != nil || lines == len(records) {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return writeFileDurable(historyFilePath(), buf.Bytes(), 0644)
+}
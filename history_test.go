@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupHistoryTest points the history at a temp dir with no current session
+func setupHistoryTest(t *testing.T) {
+	t.Helper()
+	origClaudeDir := claudeDir
+	t.Cleanup(func() {
+		setClaudeDir(origClaudeDir)
+		history = sessionHistory{}
+	})
+	setClaudeDir(t.TempDir())
+	history = sessionHistory{}
+}
+
+// TestSessionHistoryRecord tests that usage changes are appended and that
+// moving to another session ends the previous one
+func TestSessionHistoryRecord(t *testing.T) {
+	setupHistoryTest(t)
+	start := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+
+	session := &SessionData{SessionID: "a", ProjectName: "app", ModelName: "Opus 4.5", TotalTokens: 100, TotalCost: 0.5, SessionStart: start}
+	history.record(session, sourceStatusLine, start.Add(time.Minute))
+	// Unchanged usage is not written again
+	history.record(session, sourceStatusLine, start.Add(2*time.Minute))
+
+	updated := *session
+	updated.TotalTokens, updated.TotalCost = 200, 1.0
+	// The statusline's duration drifts; the first start is kept
+	updated.SessionStart = start.Add(time.Second)
+	history.record(&updated, sourceStatusLine, start.Add(3*time.Minute))
+
+	history.record(&SessionData{SessionID: "b", ProjectName: "api", TotalTokens: 5}, sourceJSONL, start.Add(time.Hour))
+
+	records, lines, err := loadHistory()
+	if err != nil {
+		t.Fatalf("loadHistory() error: %v", err)
+	}
+	if lines != 4 {
+		t.Errorf("history has %d lines, want 4", lines)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(records))
+	}
+
+	a := records[0]
+	if a.SessionID != "a" || a.TotalTokens != 200 || !a.StartedAt.Equal(start) {
+		t.Errorf("session a = %+v", a)
+	}
+	if a.EndedAt == nil || !a.EndedAt.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("session a ended at %v, want its last update", a.EndedAt)
+	}
+
+	b := records[1]
+	if b.EndedAt != nil || b.DataSource != sourceJSONL || !b.StartedAt.Equal(start.Add(time.Hour)) {
+		t.Errorf("session b = %+v, want an open jsonl session started when first seen", b)
+	}
+}
+
+// TestSessionHistoryModelsFromTranscript tests that statusline sessions get
+// their per-model usage from the transcript, read as it grows
+func TestSessionHistoryModelsFromTranscript(t *testing.T) {
+	setupHistoryTest(t)
+	dir := filepath.Join(projectsDir, "-tmp-app")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "abc.jsonl")
+	transcript := `{"type":"assistant","timestamp":"2026-01-05T09:00:00Z","message":{"model":"claude-haiku-4-5-20241022","usage":{"input_tokens":100,"output_tokens":50}}}
+{"type":"assistant","timestamp":"2026-01-05T09:01:00Z","message":{"model":"claude-opus-4-5-20251101","usage":{"input_tokens":200,"output_tokens":100}}}
+`
+	if err := os.WriteFile(path, []byte(transcript), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history.record(&SessionData{SessionID: "abc", TotalTokens: 450}, sourceStatusLine, time.Now())
+	if got := history.current.Models; len(got) != 2 || got[1].Model != "claude-opus-4-5-20251101" || got[1].InputTokens != 200 || got[1].Cost == 0 {
+		t.Fatalf("models = %+v, want haiku and opus usage", got)
+	}
+	offset := history.usage.offset
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"assistant","timestamp":"2026-01-05T09:02:00Z","message":{"model":"claude-opus-4-5-20251101","usage":{"input_tokens":10,"output_tokens":5}}}` + "\n")
+	f.Close()
+
+	history.record(&SessionData{SessionID: "abc", TotalTokens: 465}, sourceStatusLine, time.Now())
+	history.end()
+	if len(history.usage.entries) != 3 || history.usage.offset <= offset {
+		t.Errorf("transcript read to %d with %d entries, want the new line read on top of the first %d bytes",
+			history.usage.offset, len(history.usage.entries), offset)
+	}
+
+	records, _, _ := loadHistory()
+	if len(records) != 1 || len(records[0].Models) != 2 {
+		t.Fatalf("records = %+v, want one session with two models", records)
+	}
+	if m := records[0].Models[1]; m.Model != "claude-opus-4-5-20251101" || m.InputTokens != 210 || m.Cost == 0 {
+		t.Errorf("opus usage = %+v", m)
+	}
+}
+
+// TestSessionHistoryModelsDeduplicated tests that a response whose usage is
+// repeated on each content block line is counted once
+func TestSessionHistoryModelsDeduplicated(t *testing.T) {
+	setupHistoryTest(t)
+	dir := filepath.Join(projectsDir, "-tmp-app")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	line := `{"type":"assistant","timestamp":"2026-01-05T09:00:00Z","requestId":"req_1","message":{"id":"msg_1","model":"claude-opus-4-5-20251101","usage":{"input_tokens":200,"output_tokens":100}}}` + "\n"
+	other := `{"type":"assistant","timestamp":"2026-01-05T09:01:00Z","requestId":"req_2","message":{"id":"msg_2","model":"claude-opus-4-5-20251101","usage":{"input_tokens":10,"output_tokens":5}}}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "abc.jsonl"), []byte(line+line+line+other), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history.record(&SessionData{SessionID: "abc", TotalTokens: 315}, sourceStatusLine, time.Now())
+	got := history.current.Models
+	if len(got) != 1 || got[0].InputTokens != 210 || got[0].OutputTokens != 105 {
+		t.Fatalf("models = %+v, want 210 input and 105 output tokens", got)
+	}
+	if want := calculateCost("claude-opus-4-5-20251101", 210, 105); got[0].Cost != want {
+		t.Errorf("cost = %v, want %v", got[0].Cost, want)
+	}
+}
+
+// TestAppendHistoryAfterTornLine tests that a line cut short by a crash only
+// loses that line
+func TestAppendHistoryAfterTornLine(t *testing.T) {
+	setupHistoryTest(t)
+	torn := `{"session_id":"a","total_tokens":1}` + "\n" + `{"session_id":"b","tot`
+	if err := os.WriteFile(historyFilePath(), []byte(torn), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := appendHistory(HistoryRecord{SessionID: "c"}); err != nil {
+		t.Fatalf("appendHistory() error: %v", err)
+	}
+
+	records, _, _ := loadHistory()
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.SessionID)
+	}
+	if got := strings.Join(ids, ","); got != "a,c" {
+		t.Errorf("sessions = %s, want a,c", got)
+	}
+}
+
+// TestCompactHistory tests that compaction keeps the latest record of each session
+func TestCompactHistory(t *testing.T) {
+	setupHistoryTest(t)
+	for _, rec := range []HistoryRecord{
+		{SessionID: "a", TotalTokens: 1},
+		{SessionID: "b", TotalTokens: 2},
+		{SessionID: "a", TotalTokens: 3},
+	} {
+		if err := appendHistory(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := compactHistory(); err != nil {
+		t.Fatalf("compactHistory() error: %v", err)
+	}
+
+	records, lines, err := loadHistory()
+	if err != nil || lines != 2 {
+		t.Fatalf("after compaction: %d lines (%v), want 2", lines, err)
+	}
+	if records[0].SessionID != "a" || records[0].TotalTokens != 3 || records[1].SessionID != "b" {
+		t.Errorf("records = %+v", records)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// logLevel is shared by the daemon's handlers so a reload can change it
+var logLevel = new(slog.LevelVar)
+
+func (c LogConfig) level() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return 0, fmt.Errorf("unknown level %q, use debug, info, warn or error", c.Level)
+	}
+	return level, nil
+}
+
+// setupLogging points the default logger at the configured output and
+// returns a function that closes it
+func setupLogging(c LogConfig) (func(), error) {
+	level, err := c.level()
+	if err != nil {
+		return nil, err
+	}
+	logLevel.Set(level)
+
+	var w io.Writer = os.Stderr
+	closeLog := func() {}
+	if c.File != "" {
+		rw, err := newRotatingWriter(expandHome(c.File), int64(c.MaxSizeMB)<<20, c.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		w, closeLog = rw, func() { rw.Close() }
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(w, opts)
+	if c.Format == "json" {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+	return closeLog, nil
+}
+
+// rotatingWriter appends to a log file and rotates it once it would grow past
+// maxSize: the file becomes path.1, path.1 becomes path.2 and so on, keeping
+// at most maxFiles old logs. While the log file cannot be opened, lines go
+// to fallback instead of being lost, and opening is tried again on the next
+// write.
+type rotatingWriter struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	fallback io.Writer
+
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+func newRotatingWriter(path string, maxSize int64, maxFiles int) (*rotatingWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxFiles: maxFiles, fallback: os.Stderr}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size = f, info.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.file != nil && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			fmt.Fprintf(w.fallback, "Error rotating %s: %v\n", w.path, err)
+		}
+	}
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return w.fallback.Write(p)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate moves the log aside and opens a new one. The file is closed first,
+// since Windows cannot rename an open file, so a failure to reopen leaves
+// no file for Write to retry.
+func (w *rotatingWriter) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	var err error
+	if w.maxFiles == 0 {
+		err = os.Truncate(w.path, 0)
+	} else {
+		os.Remove(w.backupPath(w.maxFiles))
+		for i := w.maxFiles - 1; i >= 1; i-- {
+			os.Rename(w.backupPath(i), w.backupPath(i+1))
+		}
+		err = os.Rename(w.path, w.backupPath(1))
+	}
+
+	if openErr := w.open(); err == nil {
+		err = openErr
+	}
+	return err
+}
+
+func (w *rotatingWriter) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
+
+func (w *rotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRotatingWriter tests size-based rotation and retention
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "daemon.log")
+	w, err := newRotatingWriter(path, 10, 2)
+	if err != nil {
+		t.Fatalf("newRotatingWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, content := range want {
+		got, err := os.ReadFile(file)
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q (%v), want %q", filepath.Base(file), got, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("only max_files old logs should be kept")
+	}
+}
+
+// TestRotatingWriterAppends tests that an existing log is appended to and
+// counts towards the size limit
+func TestRotatingWriterAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	if err := os.WriteFile(path, []byte("earlier run\n"), 0644); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	w, err := newRotatingWriter(path, 16, 0)
+	if err != nil {
+		t.Fatalf("newRotatingWriter() error: %v", err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("new\n"))
+	if got, _ := os.ReadFile(path); string(got) != "earlier run\nnew\n" {
+		t.Errorf("log = %q, want the new line appended", got)
+	}
+
+	// Without old logs to keep, rotating starts the file over
+	w.Write([]byte("overflow\n"))
+	if got, _ := os.ReadFile(path); string(got) != "overflow\n" {
+		t.Errorf("log = %q, want %q", got, "overflow\n")
+	}
+}
+
+// TestRotatingWriterReopenFails tests that lines are written to the
+// fallback while the log cannot be reopened, and to the log again once it can
+func TestRotatingWriterReopenFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	path := filepath.Join(dir, "daemon.log")
+	w, err := newRotatingWriter(path, 10, 1)
+	if err != nil {
+		t.Fatalf("newRotatingWriter() error: %v", err)
+	}
+	defer w.Close()
+	var fallback strings.Builder
+	w.fallback = &fallback
+
+	w.Write([]byte("first\n"))
+	// Without its directory, the log can be neither rotated nor reopened
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Write() while the log cannot be opened: %v", err)
+	}
+	if !strings.Contains(fallback.String(), "second\n") {
+		t.Errorf("fallback = %q, want the line written there", fallback.String())
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("third\n"))
+	if got, _ := os.ReadFile(path); string(got) != "third\n" {
+		t.Errorf("log = %q, want writes to resume once it can be opened", got)
+	}
+
+	w.Close()
+	if _, err := w.Write([]byte("closed\n")); err == nil {
+		t.Error("Write() after Close() should fail")
+	}
+}
+
+// TestSetupLogging tests the level and JSON format settings
+func TestSetupLogging(t *testing.T) {
+	orig := slog.Default()
+	defer slog.SetDefault(orig)
+
+	path := filepath.Join(t.TempDir(), "daemon.log")
+	closeLog, err := setupLogging(LogConfig{Level: "warn", Format: "json", File: path, MaxSizeMB: 1})
+	if err != nil {
+		t.Fatalf("setupLogging() error: %v", err)
+	}
+	slog.Info("hidden")
+	slog.Warn("shown", "project", "demo")
+	closeLog()
+
+	data, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("log has %d lines, want 1:\n%s", len(lines), data)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v", err)
+	}
+	if entry["msg"] != "shown" || entry["project"] != "demo" {
+		t.Errorf("log entry = %v", entry)
+	}
+}
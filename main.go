@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -19,11 +20,14 @@ import (
 )
 
 const (
-	// Discord Application ID for "Clawd Code"
+	// Default Discord Application ID for "Clawd Code"
 	ClientID = "1455326944060248250"
 
-	// Polling interval as fallback
+	// Default polling interval as fallback
 	PollInterval = 3 * time.Second
+
+	// How long config file events must settle before reloading
+	configReloadDelay = 200 * time.Millisecond
 )
 
 // Model pricing per million tokens (December 2025)
@@ -64,10 +68,12 @@ type StatusLineData struct {
 		TotalInputTokens  int64 `json:"total_input_tokens"`
 		TotalOutputTokens int64 `json:"total_output_tokens"`
 	} `json:"context_window"`
+	TranscriptPath string `json:"transcript_path"`
 }
 
 // SessionData holds parsed session information
 type SessionData struct {
+	SessionID   string
 	ProjectName string
 	ProjectPath string
 	GitBranch   string
@@ -75,6 +81,14 @@ type SessionData struct {
 	TotalTokens int64
 	TotalCost   float64
 	StartTime   time.Time
+
+	// SessionStart is when the Claude Code session itself began, where the
+	// data source knows it; StartTime is when the presence started showing
+	SessionStart time.Time
+	// Models breaks usage down per model, when read from a transcript
+	Models []ModelUsage
+	// CurrentTool is the tool Claude is running right now, if any
+	CurrentTool string
 }
 
 // JSONLMessage represents a message entry in JSONL files
@@ -82,9 +96,12 @@ type JSONLMessage struct {
 	Type      string `json:"type"`
 	Timestamp string `json:"timestamp"`
 	Cwd       string `json:"cwd"`
+	RequestID string `json:"requestId"`
 	Message   struct {
-		Model string `json:"model"`
-		Usage struct {
+		ID      string          `json:"id"`
+		Model   string          `json:"model"`
+		Content json.RawMessage `json:"content"`
+		Usage   struct {
 			InputTokens  int64 `json:"input_tokens"`
 			OutputTokens int64 `json:"output_tokens"`
 		} `json:"usage"`
@@ -96,7 +113,6 @@ var (
 	projectsDir      string
 	dataFilePath     string
 	sessionStartTime = time.Now()
-	discordClient    *discord.Client
 	usingFallback    bool
 	nudgeShown       bool
 )
@@ -107,55 +123,142 @@ func init() {
 		fmt.Fprintf(os.Stderr, "Error getting home directory: %v\n", err)
 		os.Exit(1)
 	}
-	claudeDir = filepath.Join(home, ".claude")
+	setClaudeDir(filepath.Join(home, ".claude"))
+	cfg = defaultConfig()
+}
+
+// setClaudeDir points all data paths at the given Claude directory
+func setClaudeDir(dir string) {
+	claudeDir = dir
 	projectsDir = filepath.Join(claudeDir, "projects")
 	dataFilePath = filepath.Join(claudeDir, "discord-presence-data.json")
 }
 
 func main() {
-	fmt.Println(`
+	os.Exit(runCLI(os.Args[1:]))
+}
+
+// runDaemon connects to Discord and keeps the presence updated until signalled
+func runDaemon() int {
+	closeLog, err := setupLogging(cfg.Log)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to open log: %v\n", err)
+		return 1
+	}
+	defer closeLog()
+
+	// The banner is for people watching the terminal, not for log files
+	if cfg.Log.File == "" {
+		fmt.Println(`
 ╔═══════════════════════════════════════════════════════════╗
 ║     Clawd Code - Discord Rich Presence                    ║
 ║     Show your Claude Code session on Discord!             ║
 ╚═══════════════════════════════════════════════════════════╝`)
+	}
 
-	// Connect to Discord
-	fmt.Println("🔗 Connecting to Discord...")
-	discordClient = discord.NewClient(ClientID)
-	if err := discordClient.Connect(); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ Failed to connect to Discord: %v\n", err)
-		fmt.Fprintln(os.Stderr, "   Make sure Discord is running and try again.")
-		os.Exit(1)
+	// Only one daemon may run at a time; attach relies on this to start one
+	// safely. One that is just exiting gets a moment to let go of the lock.
+	if err := acquireDaemonLock(daemonLockWait); err != nil {
+		slog.Error("Cannot start daemon", "err", err, "pid", readPIDFile())
+		return 1
+	}
+	defer releaseDaemonLock()
+
+	// Record ourselves so `status` and `stop` can find us
+	daemonState = DaemonState{
+		PID:       os.Getpid(),
+		Version:   buildVersion(),
+		StartedAt: sessionStartTime,
+	}
+	if err := writePIDFile(); err != nil {
+		slog.Error("Failed to write PID file", "err", err)
+	}
+	defer cleanupDaemonFiles()
+
+	// Served straight away: attach, detach and stop find the daemon through
+	// the control API
+	if err := serveControl(); err != nil {
+		slog.Error("Failed to start control API", "err", err)
+		return 1
+	}
+	defer stopControl()
+
+	// Only one daemon writes the history, so this is a safe time to drop
+	// superseded records
+	if err := compactHistory(); err != nil {
+		slog.Error("Failed to compact session history", "err", err)
+	}
+
+	// Connect to Discord and any other sinks
+	if cfg.Sinks.Discord.Enabled {
+		slog.Info("Connecting to Discord", "client_id", cfg.ClientID)
+	}
+	// The control API may already be using them
+	daemonMu.Lock()
+	sinks = openSinks(cfg)
+	for _, r := range sinks {
+		slog.Info("Presence sink ready", "sink", r.sink.Name())
+	}
+	if err := saveDaemonState(); err != nil {
+		slog.Error("Failed to save daemon state", "err", err)
 	}
-	fmt.Println("✓ Discord RPC connected!")
+	daemonMu.Unlock()
+	defer closeSinks()
+
+	daemonMu.Lock()
+	listenMetrics(cfg.Metrics)
+	daemonMu.Unlock()
+	defer func() {
+		daemonMu.Lock()
+		listenMetrics(MetricsConfig{})
+		daemonMu.Unlock()
+	}()
 
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		<-sigChan
-		fmt.Println("\n⏹ Shutting down...")
-		discordClient.Close()
+		select {
+		case <-sigChan:
+		case <-stopRequested:
+		}
+		slog.Info("Shutting down")
+		stopControl()
+		endSession()
+		closeSinks()
+		cleanupDaemonFiles()
+		releaseDaemonLock()
+		closeLog()
 		os.Exit(0)
 	}()
 
+	// SIGUSR1/SIGUSR2 pause and resume and SIGHUP reloads the config,
+	// without going through the control API
+	watchControlSignals()
+
 	// Try initial read and show data source
-	if session := readSessionData(); session != nil {
-		updatePresence(session)
-		if usingFallback {
-			fmt.Printf("✓ Found active session: %s (using JSONL fallback)\n", session.ProjectName)
-		} else {
-			fmt.Printf("✓ Found active session: %s (using statusline data)\n", session.ProjectName)
-		}
+	if session := refreshSession(); session != nil {
+		slog.Info("Found active session", "project", session.ProjectName, "source", daemonState.DataSource)
 	} else {
-		fmt.Println("⏳ Waiting for Claude Code session...")
+		slog.Info("Waiting for Claude Code session")
 	}
 
-	fmt.Println("🎮 Discord Rich Presence is now active! Press Ctrl+C to stop.")
+	slog.Info("Discord Rich Presence is now active, press Ctrl+C to stop")
 
-	// Start watching for changes
+	// Start watching for changes; this returns once the last attached
+	// Claude Code session has exited
 	watchForChanges()
+	slog.Info("Last Claude Code session ended, shutting down")
+	endSession()
+	return 0
+}
+
+// endSession records the session shown on Discord as over
+func endSession() {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+	history.end()
 }
 
 func readStatusLineData() *SessionData {
@@ -163,7 +266,11 @@ func readStatusLineData() *SessionData {
 	if err != nil {
 		return nil
 	}
+	return parseStatusLineData(data)
+}
 
+// parseStatusLineData converts Claude Code's statusline JSON into session data
+func parseStatusLineData(data []byte) *SessionData {
 	var statusLine StatusLineData
 	if err := json.Unmarshal(data, &statusLine); err != nil {
 		return nil
@@ -183,14 +290,22 @@ func readStatusLineData() *SessionData {
 		projectName = "Unknown Project"
 	}
 
+	var sessionStart time.Time
+	if statusLine.Cost.TotalDurationMS > 0 {
+		sessionStart = time.Now().Add(-time.Duration(statusLine.Cost.TotalDurationMS) * time.Millisecond)
+	}
+
 	return &SessionData{
-		ProjectName: projectName,
-		ProjectPath: projectPath,
-		GitBranch:   getGitBranch(projectPath),
-		ModelName:   statusLine.Model.DisplayName,
-		TotalTokens: statusLine.ContextWindow.TotalInputTokens + statusLine.ContextWindow.TotalOutputTokens,
-		TotalCost:   statusLine.Cost.TotalCostUSD,
-		StartTime:   sessionStartTime,
+		SessionID:    statusLine.SessionID,
+		ProjectName:  projectName,
+		ProjectPath:  projectPath,
+		GitBranch:    getGitBranch(projectPath),
+		ModelName:    statusLine.Model.DisplayName,
+		TotalTokens:  statusLine.ContextWindow.TotalInputTokens + statusLine.ContextWindow.TotalOutputTokens,
+		TotalCost:    statusLine.Cost.TotalCostUSD,
+		StartTime:    sessionStartTime,
+		SessionStart: sessionStart,
+		CurrentTool:  transcriptCurrentTool(statusLine.TranscriptPath),
 	}
 }
 
@@ -219,6 +334,20 @@ func getGitBranch(projectPath string) string {
 	return branch
 }
 
+// decodeProjectDir turns a directory name under ~/.claude/projects back into
+// the project path it was made from
+func decodeProjectDir(encodedPath string) string {
+	// Claude Code encodes paths: / becomes -, and literal - becomes --
+	// Example: /Users/foo/my-project -> -Users-foo-my--project
+	// Must decode -- to - FIRST, then decode single - to /
+	// Use a placeholder for double dashes (escaped literal dashes)
+	projectPath := strings.ReplaceAll(encodedPath, "--", "\x00")
+	// Convert single dashes to path separators
+	projectPath = strings.ReplaceAll(projectPath, "-", "/")
+	// Restore literal dashes from placeholder
+	return strings.ReplaceAll(projectPath, "\x00", "-")
+}
+
 // findMostRecentJSONL finds the most recently modified JSONL file in ~/.claude/projects/
 func findMostRecentJSONL() (string, string, error) {
 	if _, err := os.Stat(projectsDir); os.IsNotExist(err) {
@@ -255,17 +384,7 @@ func findMostRecentJSONL() (string, string, error) {
 			return nil
 		}
 
-		// Decode the project path
-		// Claude Code encodes paths: / becomes -, and literal - becomes --
-		// Example: /Users/foo/my-project -> -Users-foo-my--project
-		// Must decode -- to - FIRST, then decode single - to /
-		encodedPath := parts[0]
-		// Use a placeholder for double dashes (escaped literal dashes)
-		projectPath := strings.ReplaceAll(encodedPath, "--", "\x00")
-		// Convert single dashes to path separators
-		projectPath = strings.ReplaceAll(projectPath, "-", "/")
-		// Restore literal dashes from placeholder
-		projectPath = strings.ReplaceAll(projectPath, "\x00", "-")
+		projectPath := decodeProjectDir(parts[0])
 
 		files = append(files, jsonlFile{
 			path:        path,
@@ -305,6 +424,9 @@ func parseJSONLSession(jsonlPath, _ string) *SessionData {
 		totalOutputTokens int64
 		lastModel         string
 		projectPath       string
+		firstTimestamp    time.Time
+		usage             = map[string]*ModelUsage{}
+		tools             toolTracker
 	)
 
 	scanner := bufio.NewScanner(file)
@@ -323,11 +445,24 @@ func parseJSONLSession(jsonlPath, _ string) *SessionData {
 			projectPath = msg.Cwd
 		}
 
+		if firstTimestamp.IsZero() && msg.Timestamp != "" {
+			firstTimestamp, _ = time.Parse(time.RFC3339, msg.Timestamp)
+		}
+		tools.observe(&msg)
+
 		// Only process assistant messages with usage data
 		if msg.Type == "assistant" && msg.Message.Model != "" {
 			lastModel = msg.Message.Model
 			totalInputTokens += msg.Message.Usage.InputTokens
 			totalOutputTokens += msg.Message.Usage.OutputTokens
+
+			u := usage[lastModel]
+			if u == nil {
+				u = &ModelUsage{Model: lastModel}
+				usage[lastModel] = u
+			}
+			u.InputTokens += msg.Message.Usage.InputTokens
+			u.OutputTokens += msg.Message.Usage.OutputTokens
 		}
 	}
 
@@ -335,8 +470,18 @@ func parseJSONLSession(jsonlPath, _ string) *SessionData {
 		return nil
 	}
 
-	// Calculate cost based on model pricing
-	totalCost := calculateCost(lastModel, totalInputTokens, totalOutputTokens)
+	// Calculate cost per model, since each is priced differently
+	models := make([]ModelUsage, 0, len(usage))
+	for _, u := range usage {
+		u.Cost = calculateCost(u.Model, u.InputTokens, u.OutputTokens)
+		models = append(models, *u)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+
+	var totalCost float64
+	for _, m := range models {
+		totalCost += m.Cost
+	}
 
 	// Get display name for model
 	modelName := formatModelName(lastModel)
@@ -349,13 +494,17 @@ func parseJSONLSession(jsonlPath, _ string) *SessionData {
 	// Use daemon start time for elapsed time display
 	// This shows how long Discord presence has been active, not total session time
 	return &SessionData{
-		ProjectName: projectName,
-		ProjectPath: projectPath,
-		GitBranch:   getGitBranch(projectPath),
-		ModelName:   modelName,
-		TotalTokens: totalInputTokens + totalOutputTokens,
-		TotalCost:   totalCost,
-		StartTime:   sessionStartTime,
+		SessionID:    strings.TrimSuffix(filepath.Base(jsonlPath), ".jsonl"),
+		ProjectName:  projectName,
+		ProjectPath:  projectPath,
+		GitBranch:    getGitBranch(projectPath),
+		ModelName:    modelName,
+		TotalTokens:  totalInputTokens + totalOutputTokens,
+		TotalCost:    totalCost,
+		StartTime:    sessionStartTime,
+		SessionStart: firstTimestamp,
+		Models:       models,
+		CurrentTool:  tools.current(),
 	}
 }
 
@@ -397,9 +546,10 @@ func formatModelName(modelID string) string {
 func readSessionData() *SessionData {
 	// First try statusline data (most accurate)
 	if data := readStatusLineData(); data != nil {
+		slog.Debug("Using statusline data", "path", dataFilePath)
 		if usingFallback {
 			usingFallback = false
-			fmt.Println("📊 Now using statusline data (more accurate)")
+			slog.Info("Now using statusline data (more accurate)")
 		}
 		return data
 	}
@@ -407,40 +557,124 @@ func readSessionData() *SessionData {
 	// Fall back to JSONL parsing
 	jsonlPath, projectPath, err := findMostRecentJSONL()
 	if err != nil {
+		slog.Debug("No session data found", "statusline", dataFilePath, "err", err)
 		return nil
 	}
 
-	if !usingFallback && !nudgeShown {
+	slog.Debug("No usable statusline data, using JSONL fallback", "transcript", jsonlPath)
+	if !usingFallback {
 		usingFallback = true
-		nudgeShown = true
-		fmt.Println("\n💡 Tip: For more accurate token/cost data, configure the statusline wrapper.")
-		fmt.Println("   See: https://github.com/tsanva/cc-discord-presence#statusline-setup")
+		slog.Info("Using JSONL fallback")
+		if !nudgeShown {
+			nudgeShown = true
+			slog.Info("For more accurate token/cost data, configure the statusline",
+				"see", "https://github.com/tsanva/cc-discord-presence#statusline-setup")
+		}
 	}
 
 	return parseJSONLSession(jsonlPath, projectPath)
 }
 
+// refreshSession reads the current session and pushes it to Discord. The
+// watch loop and the control API both call into the daemon, so everything
+// that touches presence state runs under daemonMu.
+func refreshSession() *SessionData {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+
+	now := time.Now()
+	checkQuietHours(now)
+	expireOverride()
+	totals.refresh(now)
+	checkBudgets(now)
+
+	parseStart := time.Now()
+	session := readSessionData()
+	if session != nil {
+		source := sourceStatusLine
+		if usingFallback {
+			source = sourceJSONL
+		}
+		metrics.observeParse(source, time.Since(parseStart))
+		updatePresence(session)
+	}
+	return session
+}
+
+// updatePresence records the session and shows it on Discord unless the
+// presence is hidden. Callers must hold daemonMu.
 func updatePresence(session *SessionData) {
-	// Build details line with prefix
-	details := fmt.Sprintf("Working on: %s", session.ProjectName)
-	if session.GitBranch != "" {
-		details = fmt.Sprintf("Working on: %s (%s)", session.ProjectName, session.GitBranch)
+	lastSession = session
+	recordUpdate(session)
+	history.record(session, daemonState.DataSource, time.Now())
+
+	if presenceHidden() {
+		return
+	}
+
+	showPresence(buildPresence(session))
+}
+
+// rerenderPresence shows the last session again after pause, override or
+// config changes. Callers must hold daemonMu.
+func rerenderPresence() {
+	if presenceHidden() {
+		return
 	}
 
-	// Build state line: model | tokens | cost
-	state := fmt.Sprintf("%s | %s tokens | $%.4f",
-		session.ModelName,
-		formatNumber(session.TotalTokens),
-		session.TotalCost)
+	session := lastSession
+	if session == nil {
+		if !override.active() {
+			return
+		}
+		// Nothing to show yet besides the override itself
+		session = &SessionData{StartTime: sessionStartTime}
+	}
 
-	if err := discordClient.SetActivity(discord.Activity{
+	showPresence(buildPresence(session))
+}
+
+// showPresence pushes a presence to every sink. Callers must hold daemonMu.
+func showPresence(p Presence) {
+	slog.Debug("Rendered activity", "details", p.Activity.Details, "state", p.Activity.State)
+	sinks.update(p)
+}
+
+// buildPresence renders a session for the sinks. Callers must hold daemonMu.
+func buildPresence(session *SessionData) Presence {
+	p := Presence{
+		Data: presenceData{
+			SessionData: session,
+			Today:       totals.Today,
+			Week:        totals.Week,
+			Month:       totals.Month,
+		},
+	}
+	details := renderPresenceLine("presence.details", cfg.Presence.Details, defaultDetailsTemplate, p.Data)
+	state := renderPresenceLine("presence.state", cfg.Presence.State, defaultStateTemplate, p.Data)
+
+	if override.active() {
+		o := *override
+		p.Override = &o
+		if o.Details != "" {
+			details = o.Details
+		}
+		if o.State != "" {
+			state = o.State
+		}
+	}
+
+	p.Activity = discord.Activity{
 		Details:   details,
 		State:     state,
 		LargeText: "Clawd Code - Discord Rich Presence for Claude Code",
 		StartTime: &session.StartTime,
-	}); err != nil {
-		fmt.Fprintf(os.Stderr, "Error updating presence: %v\n", err)
 	}
+	if cfg.Budgets.WarningIcon != "" && budgets.warning != nil {
+		p.Activity.SmallImage = cfg.Budgets.WarningIcon
+		p.Activity.SmallText = budgets.warning.message()
+	}
+	return p
 }
 
 func formatNumber(n int64) string {
@@ -455,7 +689,7 @@ func formatNumber(n int64) string {
 func watchForChanges() {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		fmt.Println("Using polling mode for session tracking")
+		slog.Warn("Using polling mode for session tracking", "err", err)
 		pollForChanges()
 		return
 	}
@@ -463,15 +697,31 @@ func watchForChanges() {
 
 	// Watch both the main claude dir (for statusline data) and projects dir (for JSONL fallback)
 	if err := watcher.Add(claudeDir); err != nil {
-		fmt.Println("Using polling mode for session tracking")
+		slog.Warn("Using polling mode for session tracking", "err", err)
 		pollForChanges()
 		return
 	}
 
+	// Reload the config when it changes, watching its directory as well if
+	// it lives outside the Claude directory
+	configPath, _ := daemonFlags.path()
+	configPath = filepath.Clean(configPath)
+	if dir := filepath.Dir(configPath); dir != filepath.Clean(claudeDir) {
+		if err := watcher.Add(dir); err != nil {
+			slog.Warn("Not watching config file for changes", "path", configPath, "err", err)
+		}
+	}
+
 	// Also poll as backup (especially important for JSONL which is in subdirs)
-	ticker := time.NewTicker(PollInterval)
+	interval := pollInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	sessions := newSessionWatch()
+
+	// Editors save in several steps, so reload once the events settle
+	var reload <-chan time.Time
+
 	for {
 		select {
 		case event, ok := <-watcher.Events:
@@ -480,31 +730,62 @@ func watchForChanges() {
 			}
 			// Respond to statusline data file changes
 			if filepath.Base(event.Name) == "discord-presence-data.json" {
-				if session := readSessionData(); session != nil {
-					updatePresence(session)
-				}
+				refreshSession()
+			} else if filepath.Clean(event.Name) == configPath {
+				reload = time.After(configReloadDelay)
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
 				return
 			}
-			fmt.Fprintf(os.Stderr, "Watcher error: %v\n", err)
+			slog.Error("Watcher error", "err", err)
+		case <-reload:
+			reload = nil
+			daemonMu.Lock()
+			if reportReload(reloadConfig()) == nil {
+				if err := saveDaemonState(); err != nil {
+					slog.Error("Failed to save daemon state", "err", err)
+				}
+			}
+			daemonMu.Unlock()
 		case <-ticker.C:
-			// Poll reads from either statusline or JSONL fallback
-			if session := readSessionData(); session != nil {
-				updatePresence(session)
+			if sessions.lastSessionEnded() {
+				return
 			}
+			// Poll reads from either statusline or JSONL fallback
+			refreshSession()
+		}
+
+		// Pick up a poll_interval changed by a reload
+		if d := pollInterval(); d != interval {
+			interval = d
+			ticker.Reset(interval)
 		}
 	}
 }
 
 func pollForChanges() {
-	ticker := time.NewTicker(PollInterval)
+	interval := pollInterval()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	sessions := newSessionWatch()
 	for range ticker.C {
-		if session := readSessionData(); session != nil {
-			updatePresence(session)
+		if sessions.lastSessionEnded() {
+			return
+		}
+		refreshSession()
+
+		if d := pollInterval(); d != interval {
+			interval = d
+			ticker.Reset(interval)
 		}
 	}
 }
+
+// pollInterval returns the current poll interval, which a reload can change
+func pollInterval() time.Duration {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+	return time.Duration(cfg.PollInterval)
+}
@@ -301,6 +301,8 @@ func TestParseJSONLSession(t *testing.T) {
 		wantTokens  int64
 		wantModel   string
 		wantProject string
+		wantModels  int
+		wantStart   time.Time
 	}{
 		{
 			name: "Valid session with multiple messages",
@@ -312,6 +314,17 @@ func TestParseJSONLSession(t *testing.T) {
 			wantTokens:  4500, // 1000+500+2000+1000
 			wantModel:   "Sonnet 4",
 			wantProject: "project",
+			wantModels:  1,
+		},
+		{
+			name: "Session start from first timestamp",
+			content: `{"type":"user","cwd":"/Users/test/project","timestamp":"2025-01-01T09:30:00.000Z"}
+{"type":"assistant","timestamp":"2025-01-01T09:31:00.000Z","message":{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":10,"output_tokens":5}}}`,
+			wantTokens:  15,
+			wantModel:   "Sonnet 4",
+			wantProject: "project",
+			wantModels:  1,
+			wantStart:   time.Date(2025, 1, 1, 9, 30, 0, 0, time.UTC),
 		},
 		{
 			name:    "Empty file",
@@ -333,6 +346,7 @@ invalid json line
 			wantTokens:  600,
 			wantModel:   "Haiku 4.5",
 			wantProject: "myapp",
+			wantModels:  1,
 		},
 		{
 			name: "Multiple models uses last one",
@@ -343,6 +357,7 @@ invalid json line
 			wantTokens:  450,
 			wantModel:   "Opus 4.5", // Last model used
 			wantProject: "multimodel",
+			wantModels:  2,
 		},
 	}
 
@@ -375,6 +390,22 @@ invalid json line
 			if got.ProjectName != tt.wantProject {
 				t.Errorf("ProjectName = %q, want %q", got.ProjectName, tt.wantProject)
 			}
+			if got.SessionID != "test" {
+				t.Errorf("SessionID = %q, want the transcript name %q", got.SessionID, "test")
+			}
+			if len(got.Models) != tt.wantModels {
+				t.Errorf("Models = %+v, want %d entries", got.Models, tt.wantModels)
+			}
+			var modelCost float64
+			for _, m := range got.Models {
+				modelCost += m.Cost
+			}
+			if modelCost != got.TotalCost {
+				t.Errorf("TotalCost = %v, want the sum of per-model costs %v", got.TotalCost, modelCost)
+			}
+			if !got.SessionStart.Equal(tt.wantStart) {
+				t.Errorf("SessionStart = %v, want %v", got.SessionStart, tt.wantStart)
+			}
 		})
 	}
 }
@@ -423,6 +454,9 @@ func TestPathDecoding(t *testing.T) {
 			if decoded != tt.want {
 				t.Errorf("decoding %q = %q, want %q", tt.encoded, decoded, tt.want)
 			}
+			if got := decodeProjectDir(tt.encoded); got != tt.want {
+				t.Errorf("decodeProjectDir(%q) = %q, want %q", tt.encoded, got, tt.want)
+			}
 		})
 	}
 }
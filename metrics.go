@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPrefix starts the name of every exported metric
+const metricsPrefix = "cc_discord_presence_"
+
+// parseLatencyBuckets are the upper bounds of the parse latency histogram,
+// in seconds
+var parseLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// histogram counts observations into parseLatencyBuckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(parseLatencyBuckets))
+	}
+	for i, bound := range parseLatencyBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// daemonMetrics counts what the daemon did since it started. Parse latency
+// is recorded under daemonMu; sink updates come from the sink goroutines and
+// are guarded by mu.
+type daemonMetrics struct {
+	// parseLatency is how long reading the session took, by data source
+	parseLatency map[string]*histogram
+
+	mu               sync.Mutex
+	presenceUpdates  map[string]uint64
+	presenceErrors   map[string]uint64
+	discordConnected bool
+}
+
+var metrics = &daemonMetrics{}
+
+// observeParse records how long reading the session from source took
+func (m *daemonMetrics) observeParse(source string, d time.Duration) {
+	if m.parseLatency == nil {
+		m.parseLatency = map[string]*histogram{}
+	}
+	h := m.parseLatency[source]
+	if h == nil {
+		h = &histogram{}
+		m.parseLatency[source] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// observeUpdate records the outcome of updating or clearing a sink
+func (m *daemonMetrics) observeUpdate(sink string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.presenceUpdates == nil {
+		m.presenceUpdates = map[string]uint64{}
+		m.presenceErrors = map[string]uint64{}
+	}
+	m.presenceUpdates[sink]++
+	if err != nil {
+		m.presenceErrors[sink]++
+	}
+	if sink == "discord" {
+		m.discordConnected = err == nil
+	}
+}
+
+// setDiscordConnected records that a Discord sink was opened or closed
+func (m *daemonMetrics) setDiscordConnected(connected bool) {
+	m.mu.Lock()
+	m.discordConnected = connected
+	m.mu.Unlock()
+}
+
+// metricFamily is a metric and its samples. Counter names leave off the
+// _total suffix, which is added when writing.
+type metricFamily struct {
+	name    string
+	help    string
+	kind    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	// suffix is appended to the family name, e.g. _bucket
+	suffix string
+	// labels are name and value pairs
+	labels []string
+	value  float64
+}
+
+func (f *metricFamily) add(value float64, labels ...string) {
+	f.samples = append(f.samples, metricSample{labels: labels, value: value})
+}
+
+// collectMetrics snapshots the daemon's metrics. Callers must hold daemonMu.
+func collectMetrics() []metricFamily {
+	tokens := metricFamily{name: "session_tokens", kind: "gauge",
+		help: "Tokens used in the current session by model and type."}
+	cost := metricFamily{name: "session_cost_usd", kind: "gauge",
+		help: "Cost of the current session in USD by model."}
+	totalTokens := metricFamily{name: "session_total_tokens", kind: "gauge",
+		help: "Tokens used in the current session."}
+	totalCost := metricFamily{name: "session_total_cost_usd", kind: "gauge",
+		help: "Cost of the current session in USD."}
+	if session := lastSession; session != nil {
+		for _, m := range sessionModels(session) {
+			tokens.add(float64(m.InputTokens), "model", m.Model, "type", "input")
+			tokens.add(float64(m.OutputTokens), "model", m.Model, "type", "output")
+			cost.add(m.Cost, "model", m.Model)
+		}
+		totalTokens.add(float64(session.TotalTokens))
+		totalCost.add(session.TotalCost)
+	}
+
+	active := metricFamily{name: "active_sessions", kind: "gauge",
+		help: "Claude Code sessions attached to the daemon."}
+	// Counted without pruning: a scrape only reads, the session watch cleans up
+	active.add(float64(len(registeredSessions(false))))
+
+	source := metricFamily{name: "data_source", kind: "gauge",
+		help: "Whether the session is read from statusline data or the JSONL fallback."}
+	for _, s := range []string{sourceStatusLine, sourceJSONL} {
+		source.add(boolValue(lastSession != nil && daemonState.DataSource == s), "source", s)
+	}
+
+	metrics.mu.Lock()
+	connected := metricFamily{name: "discord_connected", kind: "gauge",
+		help: "Whether the last presence update reached Discord."}
+	connected.add(boolValue(metrics.discordConnected))
+
+	updates := metricFamily{name: "presence_updates", kind: "counter",
+		help: "Presence updates and clears applied, by sink."}
+	errs := metricFamily{name: "presence_update_errors", kind: "counter",
+		help: "Presence updates and clears that failed, by sink."}
+	for _, s := range sortedKeys(metrics.presenceUpdates) {
+		updates.add(float64(metrics.presenceUpdates[s]), "sink", s)
+		errs.add(float64(metrics.presenceErrors[s]), "sink", s)
+	}
+	metrics.mu.Unlock()
+
+	latency := metricFamily{name: "parse_duration_seconds", kind: "histogram",
+		help: "Time taken to read the current session, by data source."}
+	for _, s := range sortedKeys(metrics.parseLatency) {
+		h := metrics.parseLatency[s]
+		for i, bound := range parseLatencyBuckets {
+			latency.samples = append(latency.samples, metricSample{suffix: "_bucket",
+				labels: []string{"source", s, "le", formatMetricValue(bound)}, value: float64(h.counts[i])})
+		}
+		latency.samples = append(latency.samples,
+			metricSample{suffix: "_bucket", labels: []string{"source", s, "le", "+Inf"}, value: float64(h.count)},
+			metricSample{suffix: "_sum", labels: []string{"source", s}, value: h.sum},
+			metricSample{suffix: "_count", labels: []string{"source", s}, value: float64(h.count)},
+		)
+	}
+
+	return []metricFamily{tokens, cost, totalTokens, totalCost, active, source, connected, updates, errs, latency}
+}
+
+// sessionModels returns the per-model usage of a session. The statusline has
+// none, so it comes from the history, which reads the session's transcript.
+func sessionModels(session *SessionData) []ModelUsage {
+	if len(session.Models) > 0 {
+		return session.Models
+	}
+	if history.current != nil && history.current.SessionID == session.SessionID {
+		return history.current.Models
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// writeMetrics writes metric families in the Prometheus text format, or in
+// the OpenMetrics format if openMetrics is set
+func writeMetrics(w io.Writer, families []metricFamily, openMetrics bool) error {
+	var b strings.Builder
+	for _, f := range families {
+		name := metricsPrefix + f.name
+		// OpenMetrics names the counter family without _total, the
+		// Prometheus format names it after its sample
+		familyName := name
+		if f.kind == "counter" && !openMetrics {
+			familyName += "_total"
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n", familyName, f.help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", familyName, f.kind)
+
+		for _, s := range f.samples {
+			suffix := s.suffix
+			if f.kind == "counter" {
+				suffix = "_total"
+			}
+			b.WriteString(name + suffix)
+			if len(s.labels) > 0 {
+				b.WriteByte('{')
+				for i := 0; i+1 < len(s.labels); i += 2 {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", s.labels[i], escapeLabelValue(s.labels[i+1]))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteString(" " + formatMetricValue(s.value) + "\n")
+		}
+	}
+	if openMetrics {
+		b.WriteString("# EOF\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	daemonMu.Lock()
+	families := collectMetrics()
+	daemonMu.Unlock()
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	if err := writeMetrics(w, families, openMetrics); err != nil {
+		slog.Debug("Failed to write metrics", "err", err)
+	}
+}
+
+// serveMetrics starts the metrics endpoint and returns a function that
+// stops it
+func serveMetrics(addr string) (func(), error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(l)
+	return func() { server.Close() }, nil
+}
+
+// metricsAddr is where the metrics endpoint is served, empty while it is
+// off, and stopMetrics stops it. Both are guarded by daemonMu.
+var (
+	metricsAddr string
+	stopMetrics func()
+)
+
+// listenMetrics moves the metrics endpoint to c's address, stopping it if
+// metrics are off. Callers must hold daemonMu.
+func listenMetrics(c MetricsConfig) {
+	addr := ""
+	if c.Listen != "" {
+		addr = c.addr()
+	}
+	if addr == metricsAddr {
+		return
+	}
+	if stopMetrics != nil {
+		stopMetrics()
+		metricsAddr, stopMetrics = "", nil
+		slog.Info("Stopped serving metrics")
+	}
+	if addr == "" {
+		return
+	}
+
+	stop, err := serveMetrics(addr)
+	if err != nil {
+		slog.Error("Failed to start metrics endpoint", "addr", addr, "err", err)
+		return
+	}
+	metricsAddr, stopMetrics = addr, stop
+	slog.Info("Serving metrics", "url", "http://"+addr+"/metrics")
+}
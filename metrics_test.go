@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWriteMetrics tests the Prometheus and OpenMetrics text formats
+func TestWriteMetrics(t *testing.T) {
+	families := []metricFamily{
+		{name: "session_tokens", kind: "gauge", help: "Tokens.", samples: []metricSample{
+			{labels: []string{"model", `odd "name"`, "type", "input"}, value: 1500},
+		}},
+		{name: "presence_updates", kind: "counter", help: "Updates.", samples: []metricSample{{value: 3}}},
+	}
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{
+			name: "prometheus",
+			want: `# HELP cc_discord_presence_session_tokens Tokens.
+# TYPE cc_discord_presence_session_tokens gauge
+cc_discord_presence_session_tokens{model="odd \"name\"",type="input"} 1500
+# HELP cc_discord_presence_presence_updates_total Updates.
+# TYPE cc_discord_presence_presence_updates_total counter
+cc_discord_presence_presence_updates_total 3
+`,
+		},
+		{
+			name:        "openmetrics",
+			openMetrics: true,
+			want: `# HELP cc_discord_presence_session_tokens Tokens.
+# TYPE cc_discord_presence_session_tokens gauge
+cc_discord_presence_session_tokens{model="odd \"name\"",type="input"} 1500
+# HELP cc_discord_presence_presence_updates Updates.
+# TYPE cc_discord_presence_presence_updates counter
+cc_discord_presence_presence_updates_total 3
+# EOF
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeMetrics(&b, families, tt.openMetrics); err != nil {
+				t.Fatalf("writeMetrics() error: %v", err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("writeMetrics() =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleMetrics tests the metrics served for the daemon's state
+func TestHandleMetrics(t *testing.T) {
+	setupControlTest(t)
+	origMetrics, origSource := metrics, daemonState.DataSource
+	defer func() { metrics, daemonState.DataSource = origMetrics, origSource }()
+
+	metrics = &daemonMetrics{}
+	metrics.observeUpdate("discord", nil)
+	metrics.observeUpdate("discord", errors.New("not connected"))
+	metrics.observeParse(sourceJSONL, 3*time.Millisecond)
+	daemonState.DataSource = sourceJSONL
+	lastSession = &SessionData{
+		TotalTokens: 1500,
+		TotalCost:   0.25,
+		Models: []ModelUsage{
+			{Model: "claude-opus-4-5-20251101", InputTokens: 1000, OutputTokens: 500, Cost: 0.25},
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, req)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`cc_discord_presence_session_tokens{model="claude-opus-4-5-20251101",type="input"} 1000`,
+		`cc_discord_presence_session_tokens{model="claude-opus-4-5-20251101",type="output"} 500`,
+		`cc_discord_presence_session_cost_usd{model="claude-opus-4-5-20251101"} 0.25`,
+		`cc_discord_presence_session_total_tokens 1500`,
+		`cc_discord_presence_data_source{source="statusline"} 0`,
+		`cc_discord_presence_data_source{source="jsonl"} 1`,
+		`cc_discord_presence_discord_connected 0`,
+		`cc_discord_presence_presence_updates_total{sink="discord"} 2`,
+		`cc_discord_presence_presence_update_errors_total{sink="discord"} 1`,
+		`cc_discord_presence_parse_duration_seconds_bucket{source="jsonl",le="0.0025"} 0`,
+		`cc_discord_presence_parse_duration_seconds_bucket{source="jsonl",le="0.005"} 1`,
+		`cc_discord_presence_parse_duration_seconds_count{source="jsonl"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	rec = httptest.NewRecorder()
+	handleMetrics(rec, req)
+	if !strings.HasSuffix(rec.Body.String(), "# EOF\n") {
+		t.Error("OpenMetrics response does not end with # EOF")
+	}
+}
+
+// TestMetricsConfigAddr tests the default metrics listen host
+func TestMetricsConfigAddr(t *testing.T) {
+	tests := []struct {
+		listen string
+		want   string
+	}{
+		{":9464", "127.0.0.1:9464"},
+		{"127.0.0.1:9464", "127.0.0.1:9464"},
+		{"0.0.0.0:9464", "0.0.0.0:9464"},
+		{"[::1]:9464", "[::1]:9464"},
+	}
+
+	for _, tt := range tests {
+		if got := (MetricsConfig{Listen: tt.listen}).addr(); got != tt.want {
+			t.Errorf("addr(%q) = %q, want %q", tt.listen, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os/exec"
+)
+
+// notifier shows an alert to the user
+type notifier interface {
+	Notify(title, message string) error
+}
+
+// newNotifier returns the notifier for the budgets.notifier setting. Desktop
+// notifications fall back to the log where none can be shown.
+func newNotifier(kind string) notifier {
+	if kind == "log" {
+		return logNotifier{}
+	}
+	return fallbackNotifier(append(desktopNotifiers(), logNotifier{}))
+}
+
+// logNotifier writes alerts to the daemon log
+type logNotifier struct{}
+
+func (logNotifier) Notify(title, message string) error {
+	slog.Warn(title, "message", message)
+	return nil
+}
+
+// fallbackNotifier tries each notifier in turn until one succeeds
+type fallbackNotifier []notifier
+
+func (f fallbackNotifier) Notify(title, message string) error {
+	var errs []error
+	for _, n := range f {
+		err := n.Notify(title, message)
+		if err == nil {
+			return nil
+		}
+		slog.Debug("Notifier failed, trying the next one", "err", err)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// commandNotifier runs a command with the title and message appended to args
+type commandNotifier struct {
+	name string
+	args []string
+}
+
+func (c commandNotifier) Notify(title, message string) error {
+	args := append(append([]string{}, c.args...), title, message)
+	return exec.Command(c.name, args...).Run()
+}
@@ -0,0 +1,15 @@
+//go:build darwin
+
+package main
+
+// desktopNotifiers shows notifications through AppleScript. The title and
+// message are passed as arguments, so they need no quoting.
+func desktopNotifiers() []notifier {
+	return []notifier{
+		commandNotifier{name: "osascript", args: []string{
+			"-e", "on run argv",
+			"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+			"-e", "end run",
+		}},
+	}
+}
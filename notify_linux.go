@@ -0,0 +1,30 @@
+//go:build linux
+
+package main
+
+import (
+	"github.com/godbus/dbus/v5"
+)
+
+// desktopNotifiers talk to the notification daemon over D-Bus, or through
+// notify-send if the session bus is not reachable from here
+func desktopNotifiers() []notifier {
+	return []notifier{
+		dbusNotifier{},
+		commandNotifier{name: "notify-send", args: []string{"--app-name=Claude Code"}},
+	}
+}
+
+// dbusNotifier uses the org.freedesktop.Notifications service
+type dbusNotifier struct{}
+
+func (dbusNotifier) Notify(title, message string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return err
+	}
+	obj := conn.Object("org.freedesktop.Notifications", "/org/freedesktop/Notifications")
+	return obj.Call("org.freedesktop.Notifications.Notify", 0,
+		"Claude Code", uint32(0), "", title, message,
+		[]string{}, map[string]dbus.Variant{}, int32(-1)).Err
+}
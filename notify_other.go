@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+// desktopNotifiers is empty where no notifier is supported yet, so alerts go
+// to the log
+func desktopNotifiers() []notifier {
+	return nil
+}
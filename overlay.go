@@ -0,0 +1,184 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//go:embed overlay.html
+var overlayHTML []byte
+
+// overlayHeartbeat keeps idle event streams from being closed by proxies
+const overlayHeartbeat = 15 * time.Second
+
+// overlayState is what the overlay page shows, sent as JSON on every change
+type overlayState struct {
+	// Visible is false while the presence is hidden
+	Visible     bool        `json:"visible"`
+	Details     string      `json:"details,omitempty"`
+	State       string      `json:"state,omitempty"`
+	Project     string      `json:"project,omitempty"`
+	GitBranch   string      `json:"git_branch,omitempty"`
+	Model       string      `json:"model,omitempty"`
+	TotalTokens int64       `json:"total_tokens"`
+	Tokens      string      `json:"tokens"`
+	TotalCost   float64     `json:"total_cost"`
+	Cost        string      `json:"cost"`
+	StartTime   *time.Time  `json:"start_time,omitempty"`
+	CurrentTool string      `json:"current_tool,omitempty"`
+	Today       usageTotals `json:"today"`
+}
+
+func newOverlayState(p Presence) overlayState {
+	session := p.Data.SessionData
+	return overlayState{
+		Visible:     true,
+		Details:     p.Activity.Details,
+		State:       p.Activity.State,
+		Project:     session.ProjectName,
+		GitBranch:   session.GitBranch,
+		Model:       session.ModelName,
+		TotalTokens: session.TotalTokens,
+		Tokens:      formatNumber(session.TotalTokens),
+		TotalCost:   session.TotalCost,
+		Cost:        formatCost(session.TotalCost, 2),
+		StartTime:   p.Activity.StartTime,
+		CurrentTool: session.CurrentTool,
+		Today:       p.Data.Today,
+	}
+}
+
+// overlaySink serves an overlay page and a Server-Sent Events stream of the
+// presence, for OBS browser sources
+type overlaySink struct {
+	addr   string
+	server *http.Server
+
+	mu          sync.Mutex
+	state       []byte
+	subscribers map[chan []byte]struct{}
+}
+
+func newOverlaySink(addr string) (Sink, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &overlaySink{addr: l.Addr().String(), subscribers: map[chan []byte]struct{}{}}
+	o.state, _ = json.Marshal(overlayState{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", o.handlePage)
+	mux.HandleFunc("GET /state", o.handleState)
+	mux.HandleFunc("GET /events", o.handleEvents)
+	o.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go o.server.Serve(l)
+	return o, nil
+}
+
+func (o *overlaySink) Name() string {
+	return "overlay"
+}
+
+func (o *overlaySink) Update(p Presence) error {
+	return o.publish(newOverlayState(p))
+}
+
+func (o *overlaySink) Clear() error {
+	return o.publish(overlayState{})
+}
+
+func (o *overlaySink) Close() error {
+	return o.server.Close()
+}
+
+// publish stores the state and hands it to every open stream. A stream that
+// has not sent the previous state yet gets the new one instead.
+func (o *overlaySink) publish(state overlayState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.state = data
+	for ch := range o.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- data
+	}
+	return nil
+}
+
+func (o *overlaySink) subscribe() (chan []byte, []byte) {
+	ch := make(chan []byte, 1)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.subscribers[ch] = struct{}{}
+	return ch, o.state
+}
+
+func (o *overlaySink) unsubscribe(ch chan []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.subscribers, ch)
+}
+
+func (o *overlaySink) handlePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(overlayHTML)
+}
+
+func (o *overlaySink) handleState(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	state := o.state
+	o.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(state)
+}
+
+// handleEvents streams the state as Server-Sent Events, starting with the
+// current one
+func (o *overlaySink) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch, state := o.subscribe()
+	defer o.unsubscribe(ch)
+
+	heartbeat := time.NewTicker(overlayHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		if state != nil {
+			_, err = fmt.Fprintf(w, "event: presence\ndata: %s\n\n", state)
+			state = nil
+		} else {
+			_, err = fmt.Fprint(w, ": ping\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case state = <-ch:
+		case <-heartbeat.C:
+		}
+	}
+}
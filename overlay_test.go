@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsanva/cc-discord-presence/discord"
+)
+
+// TestOverlaySink tests the overlay page, state and event stream
+func TestOverlaySink(t *testing.T) {
+	sink, err := newOverlaySink("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("newOverlaySink() error: %v", err)
+	}
+	defer sink.Close()
+	base := "http://" + sink.(*overlaySink).addr
+
+	resp, err := http.Get(base + "/")
+	if err != nil {
+		t.Fatalf("GET / error: %v", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "EventSource") {
+		t.Error("GET / did not serve the overlay page")
+	}
+
+	resp, err = http.Get(base + "/events")
+	if err != nil {
+		t.Fatalf("GET /events error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	events := make(chan overlayState)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var state overlayState
+				json.Unmarshal([]byte(data), &state)
+				events <- state
+			}
+		}
+	}()
+	next := func() overlayState {
+		t.Helper()
+		select {
+		case state := <-events:
+			return state
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return overlayState{}
+		}
+	}
+
+	if state := next(); state.Visible {
+		t.Errorf("initial state = %+v, want hidden", state)
+	}
+
+	start := time.Now()
+	sink.Update(Presence{
+		Activity: discord.Activity{Details: "Working on: app", StartTime: &start},
+		Data: presenceData{SessionData: &SessionData{
+			ProjectName: "app", GitBranch: "main", ModelName: "Opus 4.5",
+			TotalTokens: 15000, TotalCost: 0.5, CurrentTool: "Bash",
+		}},
+	})
+	state := next()
+	if !state.Visible || state.Project != "app" || state.Tokens != "15.0K" || state.Cost != "$0.50" || state.CurrentTool != "Bash" {
+		t.Errorf("state after update = %+v", state)
+	}
+
+	sink.Clear()
+	if state := next(); state.Visible {
+		t.Errorf("state after clear = %+v, want hidden", state)
+	}
+
+	resp, err = http.Get(base + "/state")
+	if err != nil {
+		t.Fatalf("GET /state error: %v", err)
+	}
+	var current overlayState
+	json.NewDecoder(resp.Body).Decode(&current)
+	resp.Body.Close()
+	if current.Visible {
+		t.Errorf("GET /state = %+v, want hidden", current)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// quiet is set while a quiet hours schedule hides the presence
+var quiet bool
+
+// presenceHidden reports whether the presence is currently kept off Discord.
+// Sessions are still tracked, so it comes back up to date. Callers must hold
+// daemonMu.
+func presenceHidden() bool {
+	return paused || quiet
+}
+
+// setPaused pauses or resumes the presence. Callers must hold daemonMu.
+func setPaused(p bool) {
+	if paused == p {
+		return
+	}
+	wasHidden := presenceHidden()
+	paused = p
+	applyVisibility(wasHidden)
+
+	if p {
+		slog.Info("Presence paused")
+	} else {
+		slog.Info("Presence resumed")
+	}
+}
+
+// checkQuietHours hides or shows the presence as quiet hours start and end.
+// Callers must hold daemonMu.
+func checkQuietHours(now time.Time) {
+	q := cfg.inQuietHours(now)
+	if quiet == q {
+		return
+	}
+	wasHidden := presenceHidden()
+	quiet = q
+	applyVisibility(wasHidden)
+
+	if q {
+		slog.Info("Quiet hours started, presence hidden")
+	} else {
+		slog.Info("Quiet hours ended")
+	}
+}
+
+// applyVisibility clears or restores the presence on every sink when the presence
+// became hidden or visible. Callers must hold daemonMu.
+func applyVisibility(wasHidden bool) {
+	switch hidden := presenceHidden(); {
+	case hidden && !wasHidden:
+		sinks.clear()
+	case !hidden && wasHidden:
+		rerenderPresence()
+	}
+}
+
+func cmdPause(args []string) int {
+	return setDaemonPaused("pause", args)
+}
+
+func cmdResume(args []string) int {
+	return setDaemonPaused("resume", args)
+}
+
+// setDaemonPaused runs the pause or resume command through the control API
+func setDaemonPaused(name string, args []string) int {
+	var common commonFlags
+	fs := newFlagSet(name, &common)
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	if !daemonRunning() {
+		fmt.Println("⏹ Discord Rich Presence is not running")
+		return 3
+	}
+
+	var state DaemonState
+	if err := callControl(http.MethodPost, "/"+name, nil, &state); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to %s: %v\n", name, err)
+		return 1
+	}
+
+	switch {
+	case state.Paused:
+		fmt.Println("⏸ Discord Rich Presence paused")
+	case state.Quiet:
+		fmt.Println("▶ Discord Rich Presence resumed, but hidden until quiet hours end")
+	default:
+		fmt.Println("▶ Discord Rich Presence resumed")
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/tsanva/cc-discord-presence/discord"
+)
+
+// defaultPrintFormat is what `print` and `watch` show without --format: the
+// same two lines as the Discord activity
+const defaultPrintFormat = "{{.Details}} | {{.State}}"
+
+// presenceSnapshot is the presence as `print` and `watch` render it, served
+// by the daemon on GET /presence. Templates see the presence data fields plus
+// the rendered Details and State lines.
+type presenceSnapshot struct {
+	presenceData
+	// Visible is false when there is no session or the presence is hidden
+	Visible bool
+	Details string
+	State   string
+}
+
+func newPresenceSnapshot(p Presence, visible bool) presenceSnapshot {
+	return presenceSnapshot{
+		presenceData: p.Data,
+		Visible:      visible,
+		Details:      p.Activity.Details,
+		State:        p.Activity.State,
+	}
+}
+
+// overlayState returns the snapshot in the JSON form the overlay serves
+func (s presenceSnapshot) overlayState() overlayState {
+	if !s.Visible || s.SessionData == nil {
+		return overlayState{}
+	}
+	start := s.StartTime
+	return newOverlayState(Presence{
+		Activity: discord.Activity{Details: s.Details, State: s.State, StartTime: &start},
+		Data:     s.presenceData,
+	})
+}
+
+// currentPresence returns the daemon's presence, or computes it from the
+// session files when no daemon answers or direct is set. Without a daemon,
+// the rolling totals are only computed when withTotals is set.
+func currentPresence(direct, withTotals bool) presenceSnapshot {
+	if !direct {
+		var s presenceSnapshot
+		if err := callControl(http.MethodGet, "/presence", nil, &s); err == nil {
+			return s
+		}
+	}
+	return computePresence(time.Now(), withTotals)
+}
+
+// computePresence renders the presence the way the daemon would, without
+// the daemon. A pause only exists in the daemon, quiet hours still apply.
+// The rolling totals mean reading every transcript of the month, too slow
+// for a prompt, so they are left at zero unless withTotals is set.
+func computePresence(now time.Time, withTotals bool) presenceSnapshot {
+	if withTotals {
+		totals.refresh(now)
+	}
+	session := readSessionData()
+	if session == nil {
+		return presenceSnapshot{}
+	}
+	return newPresenceSnapshot(buildPresence(session), !cfg.inQuietHours(now))
+}
+
+// presencePrinter renders snapshots with the --format template or as JSON
+type presencePrinter struct {
+	tmpl   *template.Template
+	asJSON bool
+	// totals is set when the output may show the rolling totals: JSON, or
+	// a template reading them directly or through Details and State
+	totals bool
+}
+
+// render returns the line to print. A hidden presence is an empty line, so
+// status bars drop the segment.
+func (p *presencePrinter) render(s presenceSnapshot) (string, error) {
+	if p.asJSON {
+		data, err := json.Marshal(s.overlayState())
+		return string(data), err
+	}
+	if !s.Visible || s.SessionData == nil {
+		return "", nil
+	}
+	return renderTemplate(p.tmpl, s)
+}
+
+// printFlags are the flags shared by `print` and `watch`
+type printFlags struct {
+	format string
+	asJSON bool
+	direct bool
+}
+
+func (f *printFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "format", defaultPrintFormat, "template for the output, with the same fields and functions as the presence templates")
+	fs.BoolVar(&f.asJSON, "json", false, "print JSON instead of the --format template")
+	fs.BoolVar(&f.direct, "direct", false, "read the session files instead of asking the running daemon")
+}
+
+// printer parses the --format template
+func (f *printFlags) printer() (*presencePrinter, error) {
+	tmpl, err := parseTemplate("format", f.format)
+	if err != nil {
+		return nil, err
+	}
+	return &presencePrinter{
+		tmpl:   tmpl,
+		asJSON: f.asJSON,
+		totals: f.asJSON || usesTotals(tmpl) || presenceUsesTotals(cfg),
+	}, nil
+}
+
+// quietLogging keeps info logs from reading the session files off stderr,
+// which some status bars show as well
+func quietLogging() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+}
+
+// cmdPrint prints the current presence once, for tmux, starship or polybar
+func cmdPrint(args []string) int {
+	var common commonFlags
+	var pf printFlags
+	fs := newFlagSet("print", &common)
+	pf.register(fs)
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	printer, err := pf.printer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Invalid --format: %v\n", err)
+		return 2
+	}
+	quietLogging()
+	line, err := printer.render(currentPresence(pf.direct, printer.totals))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to render presence: %v\n", err)
+		return 1
+	}
+	fmt.Println(line)
+	return 0
+}
+
+// cmdWatch prints the presence every time it changes, for status bars that
+// read lines from a long-running command
+func cmdWatch(args []string) int {
+	var common commonFlags
+	var pf printFlags
+	fs := newFlagSet("watch", &common)
+	pf.register(fs)
+	interval := fs.Duration("interval", 0, "how often to check for changes (default poll_interval)")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+	if *interval <= 0 {
+		*interval = time.Duration(cfg.PollInterval)
+	}
+
+	printer, err := pf.printer()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Invalid --format: %v\n", err)
+		return 2
+	}
+	quietLogging()
+	return watchPresence(os.Stdout, printer, func() presenceSnapshot {
+		return currentPresence(pf.direct, printer.totals)
+	}, time.Tick(*interval))
+}
+
+// watchPresence writes a line whenever the rendered presence changes, until
+// ticks is closed or writing fails
+func watchPresence(w io.Writer, printer *presencePrinter, current func() presenceSnapshot, ticks <-chan time.Time) int {
+	var last, lastErr string
+	first := true
+	for {
+		line, err := printer.render(current())
+		if err != nil {
+			// Warn once per distinct error rather than on every tick
+			if err.Error() != lastErr {
+				slog.Warn("Failed to render presence", "err", err)
+				lastErr = err.Error()
+			}
+		} else {
+			lastErr = ""
+			if first || line != last {
+				if _, err := fmt.Fprintln(w, line); err != nil {
+					return 1
+				}
+				last, first = line, false
+			}
+		}
+
+		if _, ok := <-ticks; !ok {
+			return 0
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// TestControlPresence tests the presence snapshot served to print and watch,
+// including its round trip through JSON
+func TestControlPresence(t *testing.T) {
+	setupControlTest(t)
+
+	get := func() presenceSnapshot {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		newControlHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/presence", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /presence = %d", rec.Code)
+		}
+		var s presenceSnapshot
+		if err := json.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body, err)
+		}
+		return s
+	}
+
+	if s := get(); s.Visible || s.SessionData != nil {
+		t.Errorf("without a session got %+v, want an empty snapshot", s)
+	}
+
+	lastSession = &SessionData{ProjectName: "demo", GitBranch: "main", ModelName: "Opus 4.5", TotalTokens: 1500, TotalCost: 0.25}
+	s := get()
+	if !s.Visible || s.Details != "Working on: demo (main)" || s.State != "Opus 4.5 | 1.5K tokens | $0.2500" {
+		t.Errorf("got %+v, want the rendered presence", s)
+	}
+	if s.SessionData == nil || s.ProjectName != "demo" || s.TotalTokens != 1500 {
+		t.Errorf("session data did not survive JSON: %+v", s.SessionData)
+	}
+
+	paused = true
+	if s := get(); s.Visible {
+		t.Error("a paused presence should not be visible")
+	}
+}
+
+// TestPresencePrinter tests rendering snapshots with a template and as JSON
+func TestPresencePrinter(t *testing.T) {
+	visible := presenceSnapshot{
+		presenceData: presenceData{
+			SessionData: &SessionData{ProjectName: "demo", ModelName: "Opus 4.5", TotalTokens: 1500, TotalCost: 0.25},
+			Today:       usageTotals{Cost: 1.5},
+		},
+		Visible: true,
+		Details: "Working on: demo",
+		State:   "Opus 4.5",
+	}
+	hidden := visible
+	hidden.Visible = false
+
+	tests := []struct {
+		name     string
+		format   string
+		asJSON   bool
+		snapshot presenceSnapshot
+		want     string
+	}{
+		{"default format", defaultPrintFormat, false, visible, "Working on: demo | Opus 4.5"},
+		{"session fields", "{{.ProjectName}} {{tokens .TotalTokens}} {{cost .Today.Cost 2}}", false, visible, "demo 1.5K $1.50"},
+		{"hidden", defaultPrintFormat, false, hidden, ""},
+		{"no session", defaultPrintFormat, false, presenceSnapshot{}, ""},
+		{"json", defaultPrintFormat, true, visible, `"project":"demo"`},
+		{"json hidden", defaultPrintFormat, true, hidden, `{"visible":false,"total_tokens":0,"tokens":"","total_cost":0,"cost":"","today":{"input_tokens":0,"output_tokens":0,"total_tokens":0,"cost":0}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pf := printFlags{format: tt.format, asJSON: tt.asJSON}
+			printer, err := pf.printer()
+			if err != nil {
+				t.Fatalf("printer() error: %v", err)
+			}
+			if wantTotals := tt.asJSON || strings.Contains(tt.format, ".Today"); printer.totals != wantTotals {
+				t.Errorf("printer().totals = %v, want %v", printer.totals, wantTotals)
+			}
+			got, err := printer.render(tt.snapshot)
+			if err != nil {
+				t.Fatalf("render() error: %v", err)
+			}
+			if !strings.Contains(got, tt.want) || (tt.want == "" && got != "") {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWatchPresence tests that watch only prints when the output changes
+func TestWatchPresence(t *testing.T) {
+	snapshot := func(project string) presenceSnapshot {
+		return presenceSnapshot{
+			presenceData: presenceData{SessionData: &SessionData{ProjectName: project}},
+			Visible:      project != "",
+		}
+	}
+	projects := []string{"a", "a", "b", "", "", "a"}
+
+	i := 0
+	current := func() presenceSnapshot {
+		s := snapshot(projects[i])
+		i++
+		return s
+	}
+	ticks := make(chan time.Time, len(projects))
+	for range projects[1:] {
+		ticks <- time.Now()
+	}
+	close(ticks)
+
+	printer := &presencePrinter{tmpl: template.Must(parseTemplate("format", "{{.ProjectName}}"))}
+	var out strings.Builder
+	if code := watchPresence(&out, printer, current, ticks); code != 0 {
+		t.Fatalf("watchPresence() = %d, want 0", code)
+	}
+	if want := "a\nb\n\na\n"; out.String() != want {
+		t.Errorf("watchPresence() wrote %q, want %q", out.String(), want)
+	}
+}
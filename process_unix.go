@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Signals that pause, resume and reload a running daemon
+var (
+	pauseSignal  os.Signal = syscall.SIGUSR1
+	resumeSignal os.Signal = syscall.SIGUSR2
+	reloadSignal os.Signal = syscall.SIGHUP
+)
+
+// processExists reports whether a process with the given PID is alive
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	// EPERM means the process exists but belongs to someone else
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// shellCommand runs a command line through the shell, like Claude Code does
+// for statusline commands
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("sh", "-c", command)
+}
+
+// isExecutable reports whether a file can be run as a command
+func isExecutable(info os.FileInfo) bool {
+	return !info.IsDir() && info.Mode()&0111 != 0
+}
+
+// detach makes a child process outlive the hook that started it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// sessionOwnerPID is the process whose lifetime an attached session follows.
+// start.sh execs the binary, so our parent is the Claude Code process.
+func sessionOwnerPID() int {
+	return os.Getppid()
+}
+
+// lockFile takes an exclusive lock on f without blocking
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,105 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// stillActive is the exit code Windows reports for a running process
+const stillActive = 259
+
+// Windows has no user signals; pause, resume and reload go through the
+// control API
+var pauseSignal, resumeSignal, reloadSignal os.Signal
+
+// processExists reports whether a process with the given PID is alive
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// shellCommand runs a command line through the shell, like Claude Code does
+// for statusline commands
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
+
+// isExecutable reports whether a file can be run as a command. Windows has
+// no executable bit, so any regular file counts.
+func isExecutable(info os.FileInfo) bool {
+	return !info.IsDir()
+}
+
+// detach makes a child process outlive the hook that started it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP,
+		HideWindow:    true,
+	}
+}
+
+// shellNames are the intermediate processes hooks run through on Windows
+var shellNames = []string{"bash.exe", "sh.exe", "powershell.exe", "pwsh.exe", "cmd.exe"}
+
+// sessionOwnerPID is the process whose lifetime an attached session follows.
+// Hooks reach us through one or more shells (Git Bash, PowerShell) that exit
+// straight away, so walk up past them to the Claude Code process.
+func sessionOwnerPID() int {
+	parents := map[uint32]windows.ProcessEntry32{}
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return os.Getppid()
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		parents[entry.ProcessID] = entry
+	}
+
+	pid := uint32(os.Getppid())
+	for i := 0; i < 10; i++ {
+		proc, ok := parents[pid]
+		if !ok || !isShell(windows.UTF16ToString(proc.ExeFile[:])) {
+			break
+		}
+		pid = proc.ParentProcessID
+	}
+	return int(pid)
+}
+
+func isShell(exe string) bool {
+	for _, name := range shellNames {
+		if strings.EqualFold(exe, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// lockFile takes an exclusive lock on f without blocking
+func lockFile(f *os.File) error {
+	var overlapped windows.Overlapped
+	return windows.LockFileEx(windows.Handle(f.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+}
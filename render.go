@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// templateFuncs are available in every user-configurable template
+var templateFuncs = template.FuncMap{
+	"tokens": formatNumber,
+	"cost":   formatCost,
+	"elapsed": func(start time.Time) string {
+		return time.Since(start).Round(time.Second).String()
+	},
+	// json quotes a value for templates that build JSON
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Default presence templates, matching what the presence always showed
+const (
+	defaultDetailsTemplate = "Working on: {{.ProjectName}}{{if .GitBranch}} ({{.GitBranch}}){{end}}"
+	defaultStateTemplate   = "{{.ModelName}} | {{tokens .TotalTokens}} tokens | {{cost .TotalCost}}"
+)
+
+// maxActivityText is the longest details or state text Discord accepts
+const maxActivityText = 128
+
+// presenceData is what presence templates are rendered with: the session's
+// fields plus the rolling totals across all sessions
+type presenceData struct {
+	*SessionData
+	Today usageTotals
+	Week  usageTotals
+	Month usageTotals
+}
+
+// formatCost formats a cost in dollars, with 4 decimals unless given
+func formatCost(usd float64, decimals ...int) string {
+	d := 4
+	if len(decimals) > 0 {
+		d = decimals[0]
+	}
+	return fmt.Sprintf("$%.*f", d, usd)
+}
+
+// truncateText shortens s to at most n runes, ending it with an ellipsis
+func truncateText(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// parseTemplate parses a user-configurable template with the shared functions
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// totalsFields are the presence data fields that need every transcript of
+// the month read
+var totalsFields = map[string]bool{"Today": true, "Week": true, "Month": true}
+
+// usesTotals reports whether a template may read the rolling totals. A
+// template passing the whole data along, like {{json .}}, counts as well.
+func usesTotals(tmpl *template.Template) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeUsesTotals(t.Tree.Root) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeUsesTotals(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if nodeUsesTotals(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeUsesTotals(n.Pipe)
+	case *parse.IfNode:
+		return branchUsesTotals(&n.BranchNode)
+	case *parse.RangeNode:
+		return branchUsesTotals(&n.BranchNode)
+	case *parse.WithNode:
+		return branchUsesTotals(&n.BranchNode)
+	case *parse.TemplateNode:
+		return n.Pipe != nil && nodeUsesTotals(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if nodeUsesTotals(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if nodeUsesTotals(arg) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return totalsFields[n.Ident[0]]
+	case *parse.ChainNode:
+		return totalsFields[n.Field[0]] || nodeUsesTotals(n.Node)
+	case *parse.VariableNode:
+		// $ alone is the whole data, $.Today one of its fields
+		return len(n.Ident) == 1 && n.Ident[0] == "$" || len(n.Ident) > 1 && totalsFields[n.Ident[1]]
+	case *parse.DotNode:
+		return true
+	}
+	return false
+}
+
+func branchUsesTotals(b *parse.BranchNode) bool {
+	return nodeUsesTotals(b.Pipe) || nodeUsesTotals(b.List) || nodeUsesTotals(b.ElseList)
+}
+
+// presenceUsesTotals reports whether the configured presence templates may
+// read the rolling totals. A template that does not parse renders its
+// default, which does not.
+func presenceUsesTotals(c *Config) bool {
+	for name, text := range map[string]string{"presence.details": c.Presence.Details, "presence.state": c.Presence.State} {
+		if tmpl, err := parseTemplate(name, text); err == nil && usesTotals(tmpl) {
+			return true
+		}
+	}
+	return false
+}
+
+// renderTemplate executes a template and trims the result to a single line
+func renderTemplate(tmpl *template.Template, data any) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.ReplaceAll(b.String(), "\n", " ")), nil
+}
+
+// renderPresenceLine renders one of the presence templates, falling back to
+// its default if the template fails
+func renderPresenceLine(name, text, fallback string, data presenceData) string {
+	tmpl, err := parseTemplate(name, text)
+	if err == nil {
+		var line string
+		if line, err = renderTemplate(tmpl, data); err == nil {
+			return truncateText(line, maxActivityText)
+		}
+	}
+	slog.Warn("Failed to render presence template, using the default", "template", name, "err", err)
+
+	tmpl = template.Must(parseTemplate(name, fallback))
+	line, _ := renderTemplate(tmpl, data)
+	return truncateText(line, maxActivityText)
+}
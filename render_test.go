@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuildPresence tests rendering the presence templates
+func TestBuildPresence(t *testing.T) {
+	origCfg, origTotals, origOverride := cfg, totals, override
+	defer func() { cfg, totals, override = origCfg, origTotals, origOverride }()
+	override = nil
+	totals = &usageTotalsTracker{periodTotals: periodTotals{Today: usageTotals{TotalTokens: 4_200_000, Cost: 12.3}}}
+
+	session := &SessionData{
+		ProjectName: "myproject",
+		GitBranch:   "main",
+		ModelName:   "Opus 4.5",
+		TotalTokens: 15000,
+		TotalCost:   0.5,
+		StartTime:   time.Now(),
+	}
+
+	tests := []struct {
+		name        string
+		details     string
+		state       string
+		wantDetails string
+		wantState   string
+	}{
+		{
+			name:        "defaults",
+			details:     defaultDetailsTemplate,
+			state:       defaultStateTemplate,
+			wantDetails: "Working on: myproject (main)",
+			wantState:   "Opus 4.5 | 15.0K tokens | $0.5000",
+		},
+		{
+			name:        "totals",
+			details:     "{{.ProjectName}}",
+			state:       "Today: {{tokens .Today.TotalTokens}} tokens | {{cost .Today.Cost 2}}",
+			wantDetails: "myproject",
+			wantState:   "Today: 4.2M tokens | $12.30",
+		},
+		{
+			name:        "failing template falls back to the default",
+			details:     "{{.ProjectName.Missing}}",
+			state:       defaultStateTemplate,
+			wantDetails: "Working on: myproject (main)",
+			wantState:   "Opus 4.5 | 15.0K tokens | $0.5000",
+		},
+		{
+			name:        "long text is truncated",
+			details:     strings.Repeat("x", 200),
+			state:       defaultStateTemplate,
+			wantDetails: strings.Repeat("x", maxActivityText-1) + "…",
+			wantState:   "Opus 4.5 | 15.0K tokens | $0.5000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = defaultConfig()
+			cfg.Presence = PresenceConfig{Details: tt.details, State: tt.state}
+
+			activity := buildPresence(session).Activity
+			if activity.Details != tt.wantDetails {
+				t.Errorf("Details = %q, want %q", activity.Details, tt.wantDetails)
+			}
+			if activity.State != tt.wantState {
+				t.Errorf("State = %q, want %q", activity.State, tt.wantState)
+			}
+		})
+	}
+}
+
+// TestUsesTotals tests telling templates that read the rolling totals from
+// ones that only need the session
+func TestUsesTotals(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{defaultDetailsTemplate, false},
+		{defaultStateTemplate, false},
+		{defaultPrintFormat, false},
+		{"{{cost .Today.Cost 2}}", true},
+		{"{{if gt .Week.TotalTokens 0}}busy{{end}}", true},
+		{"{{with .Month}}{{.Cost}}{{end}}", true},
+		{"{{range $i := .ProjectName}}{{$.Month.Cost}}{{end}}", true},
+		{"{{json .}}", true},
+		{`{{define "x"}}{{.Today}}{{end}}{{.ProjectName}}`, true},
+		{"{{.ProjectName}} {{elapsed .StartTime}}", false},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := parseTemplate("test", tt.text)
+		if err != nil {
+			t.Fatalf("parseTemplate(%q) error: %v", tt.text, err)
+		}
+		if got := usesTotals(tmpl); got != tt.want {
+			t.Errorf("usesTotals(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
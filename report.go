@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// dateLayout is how report dates are given and shown
+const dateLayout = "2006-01-02"
+
+// usageTotals sums the usage of a group of messages
+type usageTotals struct {
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	TotalTokens  int64   `json:"total_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+func (t *usageTotals) add(e usageEntry) {
+	t.InputTokens += e.InputTokens
+	t.OutputTokens += e.OutputTokens
+	t.TotalTokens += e.InputTokens + e.OutputTokens
+	t.Cost += e.Cost
+}
+
+// reportRow is one line of a breakdown
+type reportRow struct {
+	Key string `json:"key"`
+	usageTotals
+}
+
+// usageReport breaks usage down by period, project and model
+type usageReport struct {
+	Since    string      `json:"since"`
+	Until    string      `json:"until"`
+	Period   string      `json:"period"`
+	Periods  []reportRow `json:"periods"`
+	Projects []reportRow `json:"projects"`
+	Models   []reportRow `json:"models"`
+	Total    usageTotals `json:"total"`
+}
+
+// periodKey names the day, ISO week or month t falls in, in local time
+func periodKey(t time.Time, period string) string {
+	t = t.Local()
+	switch period {
+	case "week":
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "month":
+		return t.Format("2006-01")
+	default:
+		return t.Format(dateLayout)
+	}
+}
+
+// groupUsage sums entries by key. Rows are sorted by key if byKey is set,
+// otherwise most expensive first.
+func groupUsage(entries []usageEntry, key func(usageEntry) string, byKey bool) []reportRow {
+	index := map[string]int{}
+	var rows []reportRow
+	for _, e := range entries {
+		k := key(e)
+		i, ok := index[k]
+		if !ok {
+			i = len(rows)
+			index[k] = i
+			rows = append(rows, reportRow{Key: k})
+		}
+		rows[i].add(e)
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if byKey || rows[i].Cost == rows[j].Cost {
+			return rows[i].Key < rows[j].Key
+		}
+		return rows[i].Cost > rows[j].Cost
+	})
+	return rows
+}
+
+func buildReport(entries []usageEntry, since, until time.Time, period string) usageReport {
+	r := usageReport{
+		Since:  since.Format(dateLayout),
+		Until:  until.AddDate(0, 0, -1).Format(dateLayout),
+		Period: period,
+		Periods: groupUsage(entries, func(e usageEntry) string {
+			return periodKey(e.Time, period)
+		}, true),
+		Projects: groupUsage(entries, func(e usageEntry) string { return e.Project }, false),
+		Models:   groupUsage(entries, func(e usageEntry) string { return e.Model }, false),
+	}
+	for _, e := range entries {
+		r.Total.add(e)
+	}
+	return r
+}
+
+func cmdReport(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("report", &common)
+	today := time.Now().Format(dateLayout)
+	sinceFlag := fs.String("since", "", "first day to include, YYYY-MM-DD (default 30 days ago)")
+	untilFlag := fs.String("until", today, "last day to include, YYYY-MM-DD")
+	period := fs.String("period", "day", "group totals by day, week or month")
+	format := fs.String("format", "table", "output format: table, csv or json")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	switch *period {
+	case "day", "week", "month":
+	default:
+		fmt.Fprintf(os.Stderr, "❌ Unknown period %q, use day, week or month\n", *period)
+		return 2
+	}
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "❌ Unknown format %q, use table, csv or json\n", *format)
+		return 2
+	}
+
+	until, err := time.ParseInLocation(dateLayout, *untilFlag, time.Local)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Invalid --until date %q, use YYYY-MM-DD\n", *untilFlag)
+		return 2
+	}
+	// Include the whole last day
+	until = until.AddDate(0, 0, 1)
+
+	since := until.AddDate(0, 0, -30)
+	if *sinceFlag != "" {
+		if since, err = time.ParseInLocation(dateLayout, *sinceFlag, time.Local); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Invalid --since date %q, use YYYY-MM-DD\n", *sinceFlag)
+			return 2
+		}
+	}
+	if !since.Before(until) {
+		fmt.Fprintln(os.Stderr, "❌ --since must not be after --until")
+		return 2
+	}
+
+	entries, err := scanUsage(since, until)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to read transcripts: %v\n", err)
+		return 1
+	}
+	report := buildReport(entries, since, until, *period)
+
+	switch *format {
+	case "json":
+		err = writeReportJSON(os.Stdout, report)
+	case "csv":
+		err = writeReportCSV(os.Stdout, report)
+	default:
+		err = writeReportTable(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func writeReportJSON(w io.Writer, r usageReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeReportCSV writes every breakdown into one CSV, told apart by the
+// first column
+func writeReportCSV(w io.Writer, r usageReport) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"breakdown", "key", "input_tokens", "output_tokens", "total_tokens", "cost"})
+
+	record := func(breakdown, key string, t usageTotals) {
+		cw.Write([]string{
+			breakdown,
+			key,
+			strconv.FormatInt(t.InputTokens, 10),
+			strconv.FormatInt(t.OutputTokens, 10),
+			strconv.FormatInt(t.TotalTokens, 10),
+			strconv.FormatFloat(t.Cost, 'f', 4, 64),
+		})
+	}
+	for _, breakdown := range []struct {
+		name string
+		rows []reportRow
+	}{{r.Period, r.Periods}, {"project", r.Projects}, {"model", r.Models}} {
+		for _, row := range breakdown.rows {
+			record(breakdown.name, row.Key, row.usageTotals)
+		}
+	}
+	record("total", "", r.Total)
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeReportTable(w io.Writer, r usageReport) error {
+	fmt.Fprintf(w, "Claude Code usage from %s to %s\n", r.Since, r.Until)
+	if r.Total.TotalTokens == 0 {
+		fmt.Fprintln(w, "\nNo usage found")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, breakdown := range []struct {
+		title string
+		rows  []reportRow
+	}{{strings.ToUpper(r.Period), r.Periods}, {"PROJECT", r.Projects}, {"MODEL", r.Models}} {
+		// Numbers are right-aligned, so pad the keys to keep them on the left
+		width := len(breakdown.title)
+		for _, row := range breakdown.rows {
+			width = max(width, len(row.Key))
+		}
+		fmt.Fprintf(tw, "\n%-*s\tINPUT\tOUTPUT\tTOTAL\tCOST\t\n", width, breakdown.title)
+		for _, row := range breakdown.rows {
+			writeTableRow(tw, width, row.Key, row.usageTotals)
+		}
+		writeTableRow(tw, width, "Total", r.Total)
+	}
+	return tw.Flush()
+}
+
+func writeTableRow(w io.Writer, width int, key string, t usageTotals) {
+	fmt.Fprintf(w, "%-*s\t%d\t%d\t%d\t$%.2f\t\n", width, key, t.InputTokens, t.OutputTokens, t.TotalTokens, t.Cost)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTranscript writes a transcript under the projects directory
+func writeTranscript(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(projectsDir, dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestScanUsage tests scanning all transcripts for a date range
+func TestScanUsage(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	writeTranscript(t, "-tmp-my--app", "a.jsonl", `{"type":"user","cwd":"/tmp/my-app"}
+{"type":"assistant","timestamp":"2026-01-05T10:00:00Z","requestId":"r1","message":{"id":"m1","model":"claude-opus-4-5-20251101","usage":{"input_tokens":1000,"output_tokens":500}}}
+{"type":"assistant","timestamp":"2025-12-01T10:00:00Z","message":{"model":"claude-opus-4-5-20251101","usage":{"input_tokens":1,"output_tokens":1}}}
+{"type":"assistant","timestamp":"2026-01-05T11:00:00Z","message":{"model":"<synthetic>","usage":{"input_tokens":0,"output_tokens":0}}}
+`)
+	// A resumed session repeats m1, which must only be counted once
+	writeTranscript(t, "-tmp-my--app", "b.jsonl", `{"type":"assistant","timestamp":"2026-01-05T10:00:00Z","requestId":"r1","message":{"id":"m1","model":"claude-opus-4-5-20251101","usage":{"input_tokens":1000,"output_tokens":500}}}
+{"type":"assistant","timestamp":"2026-01-06T09:00:00Z","requestId":"r2","message":{"id":"m2","model":"claude-haiku-4-5-20241022","usage":{"input_tokens":200,"output_tokens":100}}}
+`)
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries, err := scanUsage(since, since.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("scanUsage() error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	for _, e := range entries {
+		if e.Project != "my-app" {
+			t.Errorf("Project = %q, want %q", e.Project, "my-app")
+		}
+	}
+}
+
+// TestBuildReport tests the per-period, per-project and per-model breakdowns
+func TestBuildReport(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 1, d, 12, 0, 0, 0, time.Local) }
+	entries := []usageEntry{
+		{Time: day(5), Project: "app", Model: "opus", InputTokens: 10, OutputTokens: 5, Cost: 1},
+		{Time: day(5), Project: "api", Model: "haiku", InputTokens: 20, OutputTokens: 5, Cost: 0.25},
+		{Time: day(12), Project: "app", Model: "opus", InputTokens: 30, OutputTokens: 10, Cost: 2},
+	}
+
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	r := buildReport(entries, since, since.AddDate(0, 1, 0), "week")
+
+	if r.Until != "2026-01-31" {
+		t.Errorf("Until = %q, want the last included day", r.Until)
+	}
+	if len(r.Periods) != 2 || r.Periods[0].Key != "2026-W02" || r.Periods[1].Key != "2026-W03" {
+		t.Errorf("Periods = %+v, want weeks 2 and 3 in order", r.Periods)
+	}
+	if len(r.Projects) != 2 || r.Projects[0].Key != "app" || r.Projects[0].TotalTokens != 55 {
+		t.Errorf("Projects = %+v, want app first with 55 tokens", r.Projects)
+	}
+	if r.Total.TotalTokens != 80 || r.Total.Cost != 3.25 {
+		t.Errorf("Total = %+v", r.Total)
+	}
+
+	var buf bytes.Buffer
+	if err := writeReportCSV(&buf, r); err != nil {
+		t.Fatalf("writeReportCSV() error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("CSV has %d lines, want header, 6 rows and total:\n%s", len(lines), buf.String())
+	}
+	if lines[1] != "week,2026-W02,30,10,40,1.2500" || lines[7] != "total,,60,20,80,3.2500" {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron-style expression with the five fields
+// "minute hour day-of-month month day-of-week". It matches every minute the
+// expression would fire in, so "* 22-23,0-6 * * *" covers 22:00 to 06:59.
+type Schedule struct {
+	expr string
+
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field. As in cron, when both day
+	// fields are restricted a time matches if either of them does.
+	domAny, dowAny bool
+}
+
+type scheduleField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var scheduleFields = []scheduleField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is accepted as Sunday like in most crons
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+func parseSchedule(expr string) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(scheduleFields) {
+		return Schedule{}, fmt.Errorf("schedule %q: want 5 fields (minute hour day month weekday), got %d", expr, len(fields))
+	}
+
+	s := Schedule{expr: expr}
+	sets := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range scheduleFields {
+		set, err := f.parse(fields[i])
+		if err != nil {
+			return Schedule{}, fmt.Errorf("schedule %q: %s: %w", expr, f.name, err)
+		}
+		*sets[i] = set
+	}
+
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return s, nil
+}
+
+// parse turns a comma-separated list of values, ranges and steps into a bitset
+func (f scheduleField) parse(field string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("range %q is backwards", rangePart)
+			}
+		default:
+			v, err := f.value(rangePart)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// "5/15" means from 5 to the end in steps of 15
+			if step == 1 {
+				hi = v
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f scheduleField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// matches reports whether t falls in a minute the schedule covers
+func (s Schedule) matches(t time.Time) bool {
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := s.dom&(1<<t.Day()) != 0
+	dowMatch := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s Schedule) String() string {
+	return s.expr
+}
+
+func (s Schedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.expr)
+}
+
+func (s *Schedule) UnmarshalJSON(b []byte) error {
+	var expr string
+	if err := json.Unmarshal(b, &expr); err != nil {
+		return fmt.Errorf("schedule must be a string like \"* 22-23 * * *\": %w", err)
+	}
+	parsed, err := parseSchedule(expr)
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
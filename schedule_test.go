@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestParseScheduleInvalid tests that malformed schedules are rejected
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* 7-5 * * *",
+		"*/0 * * * *",
+		"* * * * someday",
+	} {
+		if _, err := parseSchedule(expr); err == nil {
+			t.Errorf("parseSchedule(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+// TestScheduleMatches tests which times a schedule covers
+func TestScheduleMatches(t *testing.T) {
+	// 2026-01-05 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.January, day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(5, 12, 0), true},
+		{"* 22-23,0-6 * * *", at(5, 23, 59), true},
+		{"* 22-23,0-6 * * *", at(5, 6, 59), true},
+		{"* 22-23,0-6 * * *", at(5, 7, 0), false},
+		{"0-29 9 * * mon-fri", at(5, 9, 15), true},
+		{"0-29 9 * * mon-fri", at(5, 9, 30), false},
+		{"0-29 9 * * mon-fri", at(10, 9, 15), false},
+		{"* * * * sat,sun", at(11, 12, 0), true},
+		{"* * * * 7", at(11, 12, 0), true},
+		{"*/15 * * * *", at(5, 12, 45), true},
+		{"*/15 * * * *", at(5, 12, 46), false},
+		{"5/20 * * * *", at(5, 12, 25), true},
+		{"* * * jan *", at(5, 12, 0), true},
+		{"* * * feb *", at(5, 12, 0), false},
+		// Both day fields restricted: either one matching is enough
+		{"* * 1 * mon", at(5, 12, 0), true},
+		{"* * 1 * mon", at(1, 12, 0), true},
+		{"* * 1 * mon", at(6, 12, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := parseSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("parseSchedule() error: %v", err)
+			}
+			if got := s.matches(tt.t); got != tt.want {
+				t.Errorf("matches(%s) = %v, want %v", tt.t.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScheduleJSON tests that schedules round-trip through the config file
+func TestScheduleJSON(t *testing.T) {
+	var c Config
+	if err := json.Unmarshal([]byte(`{"quiet_hours": ["* 22-23 * * *"]}`), &c); err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	out, err := json.Marshal(c.QuietHours)
+	if err != nil || string(out) != `["* 22-23 * * *"]` {
+		t.Errorf("Marshal() = %s (%v)", out, err)
+	}
+
+	if err := json.Unmarshal([]byte(`{"quiet_hours": ["* 25 * * *"]}`), &c); err == nil {
+		t.Error("Unmarshal() should reject an invalid schedule")
+	}
+}
+
+// TestCheckQuietHours tests that quiet hours hide the presence independently
+// of pausing
+func TestCheckQuietHours(t *testing.T) {
+	setupControlTest(t)
+	s, _ := parseSchedule("* 22-23 * * *")
+	cfg.QuietHours = []Schedule{s}
+
+	night := time.Date(2026, time.January, 5, 22, 30, 0, 0, time.Local)
+	day := night.Add(-12 * time.Hour)
+
+	checkQuietHours(night)
+	if !quiet || !presenceHidden() {
+		t.Fatal("presence should be hidden during quiet hours")
+	}
+
+	// Resuming does not override quiet hours, and their end does not
+	// undo a pause
+	setPaused(true)
+	setPaused(false)
+	if !presenceHidden() {
+		t.Error("resume should not show the presence during quiet hours")
+	}
+	setPaused(true)
+	checkQuietHours(day)
+	if quiet || !presenceHidden() {
+		t.Error("presence should stay paused after quiet hours end")
+	}
+	setPaused(false)
+	if presenceHidden() {
+		t.Error("presence should be visible after resume outside quiet hours")
+	}
+}
@@ -5,6 +5,7 @@ set -e
 SCRIPT_DIR="$(cd "$(dirname "$0")" && pwd)"
 PROJECT_ROOT="$(cd "$SCRIPT_DIR/.." && pwd)"
 BIN_DIR="$PROJECT_ROOT/bin"
+VERSION="${VERSION:-$(git -C "$PROJECT_ROOT" describe --tags --always --dirty 2>/dev/null || echo dev)}"
 
 echo "🔨 Building cc-discord-presence binaries..."
 
@@ -33,7 +34,7 @@ for platform in "${platforms[@]}"; do
     fi
 
     echo "  Building ${GOOS}/${GOARCH}..."
-    GOOS="$GOOS" GOARCH="$GOARCH" go build -o "$output" .
+    GOOS="$GOOS" GOARCH="$GOARCH" go build -ldflags "-X main.version=$VERSION" -o "$output" .
 done
 
 echo ""
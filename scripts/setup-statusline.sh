@@ -1,5 +1,6 @@
 #!/bin/bash
 # Automatic statusline setup for cc-discord-presence
+# Deprecated: use `cc-discord-presence setup`, which does not need jq and can be undone
 
 CLAUDE_DIR="$HOME/.claude"
 SETTINGS_FILE="$CLAUDE_DIR/settings.json"
@@ -1,52 +1,36 @@
 # Start Discord Rich Presence daemon (Windows)
 # WARNING: Windows support is untested. Please report issues on GitHub.
+#
+# Session tracking and the single-instance lock live in the binary: this
+# script only makes sure the right binary is installed and then attaches.
 
 $ErrorActionPreference = "Stop"
 
 # Configuration
 $ClaudeDir = Join-Path $env:USERPROFILE ".claude"
 $BinDir = Join-Path $ClaudeDir "bin"
-$PidFile = Join-Path $ClaudeDir "discord-presence.pid"
-$LogFile = Join-Path $ClaudeDir "discord-presence.log"
-$RefcountFile = Join-Path $ClaudeDir "discord-presence.refcount"
 $Repo = "tsanva/cc-discord-presence"
-$Version = "v1.0.3"
+$Version = "v1.1.0"
 
 # Ensure directories exist
 New-Item -ItemType Directory -Path $ClaudeDir -Force | Out-Null
 New-Item -ItemType Directory -Path $BinDir -Force | Out-Null
 
-# Session tracking: Use refcount (PID-based tracking is unreliable on Windows)
-$CurrentCount = 0
-if (Test-Path $RefcountFile) {
-    $CurrentCount = [int](Get-Content $RefcountFile -ErrorAction SilentlyContinue)
-}
-$ActiveSessions = $CurrentCount + 1
-$ActiveSessions | Out-File -FilePath $RefcountFile -Encoding ASCII -NoNewline
-
-# If daemon is already running, just exit
-if (Test-Path $PidFile) {
-    $OldPid = Get-Content $PidFile -ErrorAction SilentlyContinue
-    if ($OldPid) {
-        $Process = Get-Process -Id $OldPid -ErrorAction SilentlyContinue
-        if ($Process) {
-            Write-Host "Discord Rich Presence already running (PID: $OldPid, sessions: $ActiveSessions)"
-            exit 0
-        }
-    }
-}
-
 $BinaryName = "cc-discord-presence-windows-amd64.exe"
 $Binary = Join-Path $BinDir $BinaryName
+$VersionFile = "$Binary.version"
 
-# Download binary if not present
-if (-not (Test-Path $Binary)) {
-    Write-Host "Downloading cc-discord-presence for windows-amd64..."
+# Download binary if not present or from another release
+$InstalledVersion = if (Test-Path $VersionFile) { (Get-Content $VersionFile -ErrorAction SilentlyContinue | Select-Object -First 1) } else { "" }
+if (-not (Test-Path $Binary) -or $InstalledVersion -ne $Version) {
+    Write-Host "Downloading cc-discord-presence $Version for windows-amd64..."
 
     $DownloadUrl = "https://github.com/$Repo/releases/download/$Version/$BinaryName"
 
     try {
-        Invoke-WebRequest -Uri $DownloadUrl -OutFile $Binary -UseBasicParsing
+        Invoke-WebRequest -Uri $DownloadUrl -OutFile "$Binary.tmp" -UseBasicParsing
+        Move-Item -Path "$Binary.tmp" -Destination $Binary -Force
+        $Version | Out-File -FilePath $VersionFile -Encoding ASCII -NoNewline
         Write-Host "Downloaded successfully!"
     } catch {
         Write-Error "Failed to download binary: $_"
@@ -54,13 +38,6 @@ if (-not (Test-Path $Binary)) {
     }
 }
 
-if (-not (Test-Path $Binary)) {
-    Write-Error "Error: Binary not found at $Binary"
-    exit 1
-}
-
-# Start the daemon in background
-$Process = Start-Process -FilePath $Binary -NoNewWindow -PassThru -RedirectStandardOutput $LogFile -RedirectStandardError $LogFile
-$Process.Id | Out-File -FilePath $PidFile -Encoding ASCII
-
-Write-Host "Discord Rich Presence started (PID: $($Process.Id), sessions: $ActiveSessions)"
+# Register this Claude Code session and start the daemon if needed
+& $Binary attach
+exit $LASTEXITCODE
@@ -1,18 +1,17 @@
 #!/bin/bash
 # Start Discord Rich Presence daemon
 # WARNING: Linux support is untested. Please report issues on GitHub.
+#
+# Session tracking and the single-instance lock live in the binary: this
+# script only makes sure the right binary is installed and then attaches.
 
 set -e
 
 # Configuration
 CLAUDE_DIR="$HOME/.claude"
 BIN_DIR="$CLAUDE_DIR/bin"
-PID_FILE="$CLAUDE_DIR/discord-presence.pid"
-LOG_FILE="$CLAUDE_DIR/discord-presence.log"
-SESSIONS_DIR="$CLAUDE_DIR/discord-presence-sessions"
-REFCOUNT_FILE="$CLAUDE_DIR/discord-presence.refcount"
 REPO="tsanva/cc-discord-presence"
-VERSION="v1.0.3"
+VERSION="v1.1.0"
 
 # Detect platform
 OS=$(uname -s | tr '[:upper:]' '[:lower:]')
@@ -21,49 +20,8 @@ case "$OS" in
     mingw*|msys*|cygwin*) IS_WINDOWS=true; OS="windows" ;;
 esac
 
-# Cross-platform process check
-process_exists() {
-    local pid=$1
-    if $IS_WINDOWS; then
-        tasklist //FI "PID eq $pid" 2>/dev/null | grep -q "$pid"
-    else
-        kill -0 "$pid" 2>/dev/null
-    fi
-}
-
 # Ensure directories exist
-mkdir -p "$CLAUDE_DIR" "$BIN_DIR" "$SESSIONS_DIR"
-
-# Session tracking: Windows uses refcount (PPID unreliable), Unix uses PID files
-if $IS_WINDOWS; then
-    CURRENT_COUNT=$(cat "$REFCOUNT_FILE" 2>/dev/null || echo "0")
-    ACTIVE_SESSIONS=$((CURRENT_COUNT + 1))
-    echo "$ACTIVE_SESSIONS" > "$REFCOUNT_FILE"
-else
-    SESSION_PID="${PPID:-$$}"
-    echo "$SESSION_PID" > "$SESSIONS_DIR/$SESSION_PID"
-
-    # Count active sessions and clean up orphans
-    ACTIVE_SESSIONS=0
-    for session_file in "$SESSIONS_DIR"/*; do
-        [[ -f "$session_file" ]] || continue
-        pid=$(basename "$session_file")
-        if process_exists "$pid"; then
-            ACTIVE_SESSIONS=$((ACTIVE_SESSIONS + 1))
-        else
-            rm -f "$session_file"
-        fi
-    done
-fi
-
-# If daemon is already running, just exit
-if [[ -f "$PID_FILE" ]]; then
-    OLD_PID=$(cat "$PID_FILE")
-    if process_exists "$OLD_PID"; then
-        echo "Discord Rich Presence already running (PID: $OLD_PID, sessions: $ACTIVE_SESSIONS)"
-        exit 0
-    fi
-fi
+mkdir -p "$CLAUDE_DIR" "$BIN_DIR"
 
 # Detect architecture
 ARCH=$(uname -m)
@@ -77,44 +35,36 @@ if [[ "$OS" == "windows" ]]; then
     BINARY_NAME="${BINARY_NAME}.exe"
 fi
 BINARY="$BIN_DIR/$BINARY_NAME"
+VERSION_FILE="$BINARY.version"
 
-# Download binary if not present
-if [[ ! -f "$BINARY" ]]; then
-    echo "Downloading cc-discord-presence for ${OS}-${ARCH}..."
+# Download binary if not present or from another release
+if [[ ! -f "$BINARY" || "$(cat "$VERSION_FILE" 2>/dev/null)" != "$VERSION" ]]; then
+    echo "Downloading cc-discord-presence ${VERSION} for ${OS}-${ARCH}..."
 
     DOWNLOAD_URL="https://github.com/${REPO}/releases/download/${VERSION}/${BINARY_NAME}"
 
     if command -v curl &> /dev/null; then
-        curl -fsSL "$DOWNLOAD_URL" -o "$BINARY"
+        curl -fsSL "$DOWNLOAD_URL" -o "$BINARY.tmp"
     elif command -v wget &> /dev/null; then
-        wget -q "$DOWNLOAD_URL" -O "$BINARY"
+        wget -q "$DOWNLOAD_URL" -O "$BINARY.tmp"
     else
         echo "Error: curl or wget required to download binary" >&2
         exit 1
     fi
 
     if ! $IS_WINDOWS; then
-        chmod +x "$BINARY"
+        chmod +x "$BINARY.tmp"
     fi
+    mv "$BINARY.tmp" "$BINARY"
+    echo "$VERSION" > "$VERSION_FILE"
     echo "Downloaded successfully!"
 fi
 
-if [[ ! -f "$BINARY" ]]; then
-    echo "Error: Binary not found at $BINARY" >&2
-    exit 1
-fi
-
-# Start the daemon in background
+# Register this Claude Code session and start the daemon if needed. On Unix
+# exec keeps our parent (Claude Code) as the session process; on Windows the
+# binary finds it by walking up past the shells.
 if $IS_WINDOWS; then
-    # On Windows, convert path to Windows format and use PowerShell
-    WIN_BINARY=$(cygpath -w "$BINARY" 2>/dev/null || echo "$BINARY")
-    WIN_PID_FILE=$(cygpath -w "$PID_FILE" 2>/dev/null || echo "$PID_FILE")
-
-    # Use PowerShell to start the process and capture PID (hidden window)
-    powershell.exe -NoProfile -WindowStyle Hidden -Command '$process = Start-Process -FilePath "'"$WIN_BINARY"'" -WindowStyle Hidden -PassThru; $process.Id | Out-File -FilePath "'"$WIN_PID_FILE"'" -Encoding ASCII -NoNewline' 2>/dev/null
+    "$BINARY" attach
 else
-    nohup "$BINARY" > "$LOG_FILE" 2>&1 &
-    echo $! > "$PID_FILE"
+    exec "$BINARY" attach --pid "${PPID:-$$}"
 fi
-
-echo "Discord Rich Presence started (PID: $(cat "$PID_FILE" 2>/dev/null || echo "unknown"), sessions: $ACTIVE_SESSIONS)"
@@ -1,6 +1,7 @@
 #!/bin/bash
 # Statusline wrapper for Discord Rich Presence
 # Saves statusline data for the Discord daemon, then pipes to original statusline (if exists)
+# Deprecated: use the binary's `statusline` command instead, which handles multi-line JSON
 
 DATA_FILE="$HOME/.claude/discord-presence-data.json"
 ORIGINAL_STATUSLINE="$HOME/.claude/statusline.sh"
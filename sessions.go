@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errDaemonRunning is returned when another daemon holds the lock
+var errDaemonRunning = errors.New("another daemon is already running")
+
+// errSessionsBusy is returned when the sessions lock stays taken
+var errSessionsBusy = errors.New("timed out waiting for another attach or detach")
+
+// daemonLockWait is how long a starting daemon waits for one that is
+// exiting to release the lock
+const daemonLockWait = 5 * time.Second
+
+// sessionsLockWait is how long attach and detach wait for each other. Either
+// may hold the lock while it starts or stops the daemon.
+const sessionsLockWait = 15 * time.Second
+
+// daemonLock is held for the lifetime of the daemon. The OS releases it when
+// the process exits, so a crashed daemon never leaves a stale lock behind.
+var daemonLock *os.File
+
+func lockFilePath() string {
+	return filepath.Join(claudeDir, "discord-presence.lock")
+}
+
+func sessionsLockPath() string {
+	return filepath.Join(claudeDir, "discord-presence-sessions.lock")
+}
+
+func sessionsDir() string {
+	return filepath.Join(claudeDir, "discord-presence-sessions")
+}
+
+func logFilePath() string {
+	return filepath.Join(claudeDir, "discord-presence.log")
+}
+
+// startupErrorPath receives the stderr of a daemon started by attach, which
+// is where it reports errors from before its log was open
+func startupErrorPath() string {
+	return filepath.Join(claudeDir, "discord-presence-startup.log")
+}
+
+// acquireDaemonLock makes this process the only running daemon, waiting up
+// to wait for another one to exit
+func acquireDaemonLock(wait time.Duration) error {
+	f, err := waitForLock(lockFilePath(), wait)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		return errDaemonRunning
+	}
+	daemonLock = f
+	return nil
+}
+
+// lockSessions serializes attach, detach and the daemon's check for its last
+// session ending, so none of them acts on a session list another is
+// changing. It is only held for one such step, and released by calling
+// unlock.
+func lockSessions(wait time.Duration) (unlock func(), err error) {
+	f, err := waitForLock(sessionsLockPath(), wait)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, errSessionsBusy
+	}
+	return func() { f.Close() }, nil
+}
+
+// waitForLock takes an exclusive lock on the file at path, trying until wait
+// has passed. The file is nil if the lock stayed taken; closing it releases
+// the lock.
+func waitForLock(path string, wait time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for lockFile(f) != nil {
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return f, nil
+}
+
+func releaseDaemonLock() {
+	if daemonLock != nil {
+		daemonLock.Close()
+		daemonLock = nil
+	}
+}
+
+// daemonRunning reports whether a daemon answers on the control socket. It
+// only looks: taking the lock, even briefly, could make a daemon that is
+// starting right then believe another one owns it.
+func daemonRunning() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := dialControl(ctx)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// registerSession records a Claude Code session by the PID of its process
+func registerSession(pid int) error {
+	if err := os.MkdirAll(sessionsDir(), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(sessionsDir(), strconv.Itoa(pid)), []byte(strconv.Itoa(pid)), 0644)
+}
+
+func unregisterSession(pid int) error {
+	err := os.Remove(filepath.Join(sessionsDir(), strconv.Itoa(pid)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// liveSessions returns the PIDs of registered sessions that are still
+// running, removing registrations left behind by sessions that died
+func liveSessions() []int {
+	return registeredSessions(true)
+}
+
+// registeredSessions returns the PIDs of registered sessions that are still
+// running. Registrations of dead sessions are removed if prune is set, and
+// skipped otherwise.
+func registeredSessions(prune bool) []int {
+	entries, err := os.ReadDir(sessionsDir())
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if processExists(pid) {
+			pids = append(pids, pid)
+		} else if prune {
+			os.Remove(filepath.Join(sessionsDir(), e.Name()))
+		}
+	}
+	sort.Ints(pids)
+	return pids
+}
+
+// sessionWatch tracks whether the daemon should exit because every session
+// it was started for has ended
+type sessionWatch struct {
+	attached bool
+}
+
+// newSessionWatch starts watching, noting the sessions that attach
+// registered before starting the daemon
+func newSessionWatch() *sessionWatch {
+	return &sessionWatch{attached: len(liveSessions()) > 0}
+}
+
+// lastSessionEnded reports whether sessions were registered and none are
+// left. A daemon started by hand with no sessions keeps running. Once the
+// last session ended, the control API is stopped before attach can look, so
+// a session attaching from then on starts a new daemon.
+func (w *sessionWatch) lastSessionEnded() bool {
+	// Attach or detach is busy, look again on the next tick
+	unlock, err := lockSessions(0)
+	if err != nil {
+		return false
+	}
+	defer unlock()
+
+	if len(liveSessions()) > 0 {
+		w.attached = true
+		return false
+	}
+	if w.attached {
+		stopControl()
+	}
+	return w.attached
+}
+
+// daemonArgs returns the arguments that start `run` with the same flags as
+// the command starting it, and the file the daemon will log to
+func daemonArgs(common *commonFlags) (args []string, logPath string) {
+	args = []string{"run"}
+	if common.configPath != "" {
+		args = append(args, "--config", common.configPath)
+	}
+	if common.clientID != "" {
+		args = append(args, "--client-id", common.clientID)
+	}
+	if common.ipcPath != "" {
+		args = append(args, "--ipc-path", common.ipcPath)
+	}
+	if common.claudeDir != "" {
+		args = append(args, "--claude-dir", common.claudeDir)
+	}
+
+	// The daemon has no terminal, so it must log to a file; it rotates the
+	// file itself
+	logPath = cfg.Log.File
+	if logPath == "" {
+		logPath = logFilePath()
+		args = append(args, "--log-file", logPath)
+	}
+	return args, logPath
+}
+
+// startDaemon launches `run` in the background and waits until it holds the lock
+func startDaemon(common *commonFlags) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	stderr, err := os.Create(startupErrorPath())
+	if err != nil {
+		return 0, err
+	}
+	defer stderr.Close()
+
+	args, logPath := daemonArgs(common)
+	cmd := exec.Command(exe, args...)
+	cmd.Stderr = stderr
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// If another attach raced us, our child loses the lock and exits, so
+	// report whichever daemon ended up owning the PID file
+	deadline := time.After(5 * time.Second)
+	for !daemonRunning() {
+		select {
+		case err := <-exited:
+			// The daemon that won writes its PID right after taking the
+			// lock, so wait for it to answer instead
+			if pid := readPIDFile(); pid != cmd.Process.Pid && processExists(pid) {
+				exited = nil
+				continue
+			}
+			return 0, startupError(err, logPath)
+		case <-deadline:
+			return cmd.Process.Pid, nil
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if pid := readPIDFile(); processExists(pid) {
+		return pid, nil
+	}
+	return cmd.Process.Pid, nil
+}
+
+// startupError explains why a daemon exited during startup, using the first
+// line it wrote to stderr if there is one
+func startupError(exitErr error, logPath string) error {
+	data, _ := os.ReadFile(startupErrorPath())
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(strings.TrimPrefix(line, "❌")); line != "" {
+			return fmt.Errorf("daemon exited during startup: %s (see %s)", line, startupErrorPath())
+		}
+	}
+	return fmt.Errorf("daemon exited during startup (%v), see %s", exitErr, expandHome(logPath))
+}
+
+// stopDaemon asks the daemon to exit over the control API and waits until
+// it has. The PID comes from the daemon itself, so a PID file left behind by
+// a crash never gets another process killed. It returns 0 if no daemon
+// answers.
+func stopDaemon(timeout time.Duration) (int, error) {
+	var state DaemonState
+	if err := callControl(http.MethodPost, "/stop", nil, &state); err != nil {
+		if !daemonRunning() {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	pid := state.PID
+	deadline := time.Now().Add(timeout)
+	for processExists(pid) {
+		if time.Now().After(deadline) {
+			return pid, fmt.Errorf("daemon (PID: %d) did not exit within %s", pid, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return pid, nil
+}
+
+func cmdAttach(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("attach", &common)
+	pid := fs.Int("pid", 0, "PID of the Claude Code session (default: the calling process)")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+	if *pid == 0 {
+		*pid = sessionOwnerPID()
+	}
+
+	// Held until the daemon is known to run, so a detach cannot stop it in
+	// between
+	unlock, err := lockSessions(sessionsLockWait)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to register session: %v\n", err)
+		return 1
+	}
+	defer unlock()
+
+	if err := registerSession(*pid); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to register session: %v\n", err)
+		return 1
+	}
+	sessions := len(liveSessions())
+
+	if daemonRunning() {
+		fmt.Printf("Discord Rich Presence already running (PID: %d, sessions: %d)\n", readPIDFile(), sessions)
+		return 0
+	}
+
+	daemonPID, err := startDaemon(&common)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to start daemon: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Discord Rich Presence started (PID: %d, sessions: %d)\n", daemonPID, sessions)
+	return 0
+}
+
+func cmdDetach(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("detach", &common)
+	pid := fs.Int("pid", 0, "PID of the Claude Code session (default: the calling process)")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+	if *pid == 0 {
+		*pid = sessionOwnerPID()
+	}
+
+	// Held until the daemon is stopped, so an attach cannot count on it in
+	// between
+	unlock, err := lockSessions(sessionsLockWait)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to unregister session: %v\n", err)
+		return 1
+	}
+	defer unlock()
+
+	if err := unregisterSession(*pid); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to unregister session: %v\n", err)
+		return 1
+	}
+
+	if sessions := len(liveSessions()); sessions > 0 {
+		fmt.Printf("Discord Rich Presence still in use by %d session(s)\n", sessions)
+		return 0
+	}
+
+	stopped, err := stopDaemon(5 * time.Second)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	if stopped != 0 {
+		fmt.Printf("Discord Rich Presence stopped (PID: %d)\n", stopped)
+	}
+	return 0
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deadPID returns the PID of a process that has already exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	name := "true"
+	if runtime.GOOS == "windows" {
+		name = "hostname"
+	}
+	cmd := exec.Command(name)
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run %s: %v", name, err)
+	}
+	return cmd.Process.Pid
+}
+
+// TestDaemonLock tests that only one holder of the daemon lock can exist
+func TestDaemonLock(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	if err := acquireDaemonLock(0); err != nil {
+		t.Fatalf("acquireDaemonLock(0) error: %v", err)
+	}
+
+	// A second daemon (a different open file) must be refused
+	held := daemonLock
+	daemonLock = nil
+	if err := acquireDaemonLock(0); err != errDaemonRunning {
+		t.Errorf("second acquireDaemonLock(0) = %v, want errDaemonRunning", err)
+	}
+	daemonLock = held
+
+	releaseDaemonLock()
+	if err := acquireDaemonLock(0); err != nil {
+		t.Errorf("acquireDaemonLock(0) after release error: %v", err)
+	}
+	releaseDaemonLock()
+}
+
+// TestDaemonRunning tests that a daemon is detected by its control socket,
+// without touching the lock a starting daemon needs
+func TestDaemonRunning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("control pipe names are global on Windows")
+	}
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	if daemonRunning() {
+		t.Fatal("daemonRunning() = true with no daemon")
+	}
+	if err := serveControl(); err != nil {
+		t.Fatalf("serveControl() error: %v", err)
+	}
+	if !daemonRunning() {
+		t.Error("daemonRunning() = false while the control API is served")
+	}
+
+	// Checking must leave the lock free for the daemon itself
+	if err := acquireDaemonLock(0); err != nil {
+		t.Errorf("acquireDaemonLock(0) after daemonRunning() error: %v", err)
+	}
+	releaseDaemonLock()
+
+	stopControl()
+	os.Remove(controlSocketPath())
+	if daemonRunning() {
+		t.Error("daemonRunning() = true after the control API stopped")
+	}
+}
+
+// TestSessionRegistration tests registering sessions and pruning dead ones
+func TestSessionRegistration(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	watch := newSessionWatch()
+	if watch.lastSessionEnded() {
+		t.Error("a daemon with no sessions ever registered should keep running")
+	}
+
+	self, dead := os.Getpid(), deadPID(t)
+	if err := registerSession(self); err != nil {
+		t.Fatalf("registerSession() error: %v", err)
+	}
+	if err := registerSession(dead); err != nil {
+		t.Fatalf("registerSession() error: %v", err)
+	}
+
+	// Counting alone leaves the dead registration in place
+	if live := registeredSessions(false); len(live) != 1 || live[0] != self {
+		t.Errorf("registeredSessions(false) = %v, want [%d]", live, self)
+	}
+	if _, err := os.Stat(filepath.Join(sessionsDir(), strconv.Itoa(dead))); err != nil {
+		t.Errorf("registeredSessions(false) removed a registration: %v", err)
+	}
+
+	live := liveSessions()
+	if len(live) != 1 || live[0] != self {
+		t.Errorf("liveSessions() = %v, want [%d]", live, self)
+	}
+	if watch.lastSessionEnded() {
+		t.Error("lastSessionEnded() = true with a live session")
+	}
+
+	if err := unregisterSession(self); err != nil {
+		t.Fatalf("unregisterSession() error: %v", err)
+	}
+	if !watch.lastSessionEnded() {
+		t.Error("lastSessionEnded() = false after the last session was removed")
+	}
+	if entries, _ := os.ReadDir(sessionsDir()); len(entries) != 0 {
+		t.Errorf("sessions dir still has %d entries, dead sessions should be pruned", len(entries))
+	}
+}
+
+// TestDaemonArgs tests that the daemon started by attach gets the flags
+// attach was given
+func TestDaemonArgs(t *testing.T) {
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = defaultConfig()
+
+	tests := []struct {
+		name   string
+		common commonFlags
+		logged string
+		want   []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"run", "--log-file", logFilePath()},
+		},
+		{
+			name:   "every flag",
+			common: commonFlags{configPath: "/c.json", clientID: "42", ipcPath: "/run/discord-ipc-1", claudeDir: "/claude"},
+			want:   []string{"run", "--config", "/c.json", "--client-id", "42", "--ipc-path", "/run/discord-ipc-1", "--claude-dir", "/claude", "--log-file", logFilePath()},
+		},
+		{
+			name:   "log file from the config",
+			common: commonFlags{ipcPath: "/run/discord-ipc-0"},
+			logged: "/var/log/presence.log",
+			want:   []string{"run", "--ipc-path", "/run/discord-ipc-0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.Log.File = tt.logged
+			args, logPath := daemonArgs(&tt.common)
+			if !slices.Equal(args, tt.want) {
+				t.Errorf("daemonArgs() = %q, want %q", args, tt.want)
+			}
+			if tt.logged != "" && logPath != tt.logged {
+				t.Errorf("log path = %q, want %q", logPath, tt.logged)
+			}
+		})
+	}
+}
+
+// TestStartupError tests that a daemon exiting during startup is reported
+// with what it wrote to stderr
+func TestStartupError(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	exitErr := errors.New("exit status 1")
+	if err := startupError(exitErr, "/logs/presence.log"); !strings.Contains(err.Error(), "/logs/presence.log") {
+		t.Errorf("startupError() = %v, want the log path with no stderr output", err)
+	}
+
+	output := "\n❌ Failed to open log: permission denied\nmore\n"
+	if err := os.WriteFile(startupErrorPath(), []byte(output), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := startupError(exitErr, "/logs/presence.log")
+	if want := "daemon exited during startup: Failed to open log: permission denied"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("startupError() = %v, want prefix %q", err, want)
+	}
+}
+
+// TestSessionsLock tests that the daemon does not decide its last session
+// ended while attach or detach holds the sessions lock
+func TestSessionsLock(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	watch := &sessionWatch{attached: true}
+	unlock, err := lockSessions(0)
+	if err != nil {
+		t.Fatalf("lockSessions() error: %v", err)
+	}
+	if _, err := lockSessions(0); err != errSessionsBusy {
+		t.Errorf("second lockSessions() = %v, want errSessionsBusy", err)
+	}
+	if watch.lastSessionEnded() {
+		t.Error("lastSessionEnded() = true while the sessions lock is held")
+	}
+
+	unlock()
+	if !watch.lastSessionEnded() {
+		t.Error("lastSessionEnded() = false once the lock is free and no session is left")
+	}
+}
+
+// TestStopDaemon tests stopping the daemon through the control API, which
+// reports the PID to wait for
+func TestStopDaemon(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("control pipe names are global on Windows")
+	}
+	setupControlTest(t)
+	origState := daemonState
+	defer func() { daemonState = origState }()
+
+	if pid, err := stopDaemon(time.Second); pid != 0 || err != nil {
+		t.Errorf("stopDaemon() with no daemon = %d, %v, want 0, nil", pid, err)
+	}
+
+	daemonState = DaemonState{PID: deadPID(t)}
+	if err := serveControl(); err != nil {
+		t.Fatalf("serveControl() error: %v", err)
+	}
+	defer stopControl()
+
+	pid, err := stopDaemon(time.Second)
+	if err != nil || pid != daemonState.PID {
+		t.Errorf("stopDaemon() = %d, %v, want the daemon's PID %d", pid, err, daemonState.PID)
+	}
+	select {
+	case <-stopRequested:
+	default:
+		t.Error("POST /stop did not ask the daemon to shut down")
+	}
+}
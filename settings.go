@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// settingsFile is Claude Code's settings.json, kept as an ordered list of
+// top-level keys with their raw values so that rewriting it preserves keys
+// we don't know about, their order and their formatting
+type settingsFile struct {
+	entries []settingsEntry
+	indent  string
+}
+
+type settingsEntry struct {
+	key   string
+	value json.RawMessage
+}
+
+func settingsPath() string {
+	return filepath.Join(claudeDir, "settings.json")
+}
+
+// statusLineBackupPath holds the statusLine setting that setup replaced
+func statusLineBackupPath() string {
+	return filepath.Join(claudeDir, "discord-presence-statusline-backup.json")
+}
+
+// parseSettings reads a settings.json document. Empty input is treated as an
+// empty object.
+func parseSettings(data []byte) (*settingsFile, error) {
+	s := &settingsFile{indent: detectIndent(data)}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return s, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("settings must be a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected token %v", tok)
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, fmt.Errorf("reading %q: %w", key, err)
+		}
+		s.entries = append(s.entries, settingsEntry{key: key, value: value})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, fmt.Errorf("unexpected data after settings object")
+	}
+	return s, nil
+}
+
+// detectIndent returns the indentation used by the first indented line,
+// defaulting to two spaces
+func detectIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+func (s *settingsFile) get(key string) (json.RawMessage, bool) {
+	for _, e := range s.entries {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces a key in place, or appends it if it is new
+func (s *settingsFile) set(key string, value any) error {
+	raw, err := json.MarshalIndent(value, s.indent, s.indent)
+	if err != nil {
+		return err
+	}
+	for i, e := range s.entries {
+		if e.key == key {
+			s.entries[i].value = raw
+			return nil
+		}
+	}
+	s.entries = append(s.entries, settingsEntry{key: key, value: raw})
+	return nil
+}
+
+func (s *settingsFile) setRaw(key string, raw json.RawMessage) {
+	for i, e := range s.entries {
+		if e.key == key {
+			s.entries[i].value = raw
+			return
+		}
+	}
+	s.entries = append(s.entries, settingsEntry{key: key, value: raw})
+}
+
+func (s *settingsFile) remove(key string) {
+	for i, e := range s.entries {
+		if e.key == key {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *settingsFile) bytes() []byte {
+	if len(s.entries) == 0 {
+		return []byte("{}\n")
+	}
+	var b bytes.Buffer
+	b.WriteString("{\n")
+	for i, e := range s.entries {
+		key, _ := json.Marshal(e.key)
+		b.WriteString(s.indent)
+		b.Write(key)
+		b.WriteString(": ")
+		b.Write(e.value)
+		if i < len(s.entries)-1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// statusLineSetting is the statusLine value in settings.json
+type statusLineSetting struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	Padding *int   `json:"padding,omitempty"`
+}
+
+// presenceStatusLineCommand is the statusLine command that runs this binary
+func presenceStatusLineCommand() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return statusLineCommandFor(exe, runtime.GOOS), nil
+}
+
+// statusLineCommandFor builds the statusLine command running exe, quoted for
+// the shell Claude Code runs it with on goos
+func statusLineCommandFor(exe, goos string) string {
+	if strings.ContainsAny(exe, " '\"&;()$`|<>") {
+		if goos == "windows" {
+			// cmd takes a double-quoted path as is, backslashes included,
+			// and Windows paths cannot contain double quotes
+			exe = `"` + exe + `"`
+		} else {
+			// sh keeps everything in single quotes literal but the quote
+			exe = "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'"
+		}
+	}
+	return exe + " statusline"
+}
+
+// splitStatusLineCommand splits a statusLine command into the program it
+// runs and its arguments, undoing the quoting of statusLineCommandFor: a
+// double-quoted path is taken as is, and single-quoted parts are joined
+// with the escaped quotes between them. Other backslashes are kept, since
+// they separate Windows paths.
+func splitStatusLineCommand(command string) (exe, args string) {
+	command = strings.TrimSpace(command)
+	var b strings.Builder
+	i := 0
+	for i < len(command) && command[i] != ' ' && command[i] != '\t' {
+		switch c := command[i]; {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(command[i+1:], c)
+			if end < 0 {
+				// Unbalanced, so take the rest as it is
+				b.WriteString(command[i:])
+				i = len(command)
+				continue
+			}
+			b.WriteString(command[i+1 : i+1+end])
+			i += end + 2
+		case c == '\\' && strings.HasPrefix(command[i:], `\'`):
+			b.WriteByte('\'')
+			i += 2
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), strings.TrimSpace(command[i:])
+}
+
+// backedUpStatusLine returns the command of the statusLine that setup
+// replaced, if any
+func backedUpStatusLine() string {
+	data, err := os.ReadFile(statusLineBackupPath())
+	if err != nil {
+		return ""
+	}
+	var backup statusLineSetting
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return ""
+	}
+	return backup.Command
+}
+
+// settingsChange is a planned rewrite of settings.json plus the backup file
+type settingsChange struct {
+	before, after []byte
+	// backup is written to the backup file when non-nil
+	backup json.RawMessage
+	// removeBackup deletes the backup file
+	removeBackup bool
+	message      string
+}
+
+func loadSettings() ([]byte, *settingsFile, error) {
+	data, err := os.ReadFile(settingsPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	settings, err := parseSettings(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", settingsPath(), err)
+	}
+	return data, settings, nil
+}
+
+// planSetup works out how to point the statusLine at command
+func planSetup(command string) (*settingsChange, error) {
+	before, settings, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	change := &settingsChange{before: before}
+
+	current := statusLineSetting{Type: "command"}
+	raw, exists := settings.get("statusLine")
+	if exists {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return nil, fmt.Errorf("parsing statusLine: %w", err)
+		}
+	}
+
+	switch {
+	case current.Command == command:
+		change.after = before
+		change.message = "Statusline is already set up"
+		return change, nil
+	case strings.Contains(current.Command, "statusline-wrapper.sh"):
+		// Migrating from the wrapper script, which chained to statusline.sh
+		if info, err := os.Stat(legacyStatusLinePath()); err == nil && isExecutable(info) {
+			change.backup, _ = json.Marshal(statusLineSetting{Type: "command", Command: legacyStatusLinePath()})
+		}
+	case isPresenceStatusLine(current.Command):
+		// Another copy of this binary; keep whatever backup it made
+	case exists:
+		change.backup = raw
+	}
+
+	updated := current
+	updated.Type = "command"
+	updated.Command = command
+	if err := settings.set("statusLine", updated); err != nil {
+		return nil, err
+	}
+	change.after = settings.bytes()
+	change.message = "Statusline now runs " + command
+	return change, nil
+}
+
+// planUninstall works out how to restore the statusLine setup replaced
+func planUninstall() (*settingsChange, error) {
+	before, settings, err := loadSettings()
+	if err != nil {
+		return nil, err
+	}
+	change := &settingsChange{before: before, after: before}
+
+	var current statusLineSetting
+	if raw, ok := settings.get("statusLine"); ok {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return nil, fmt.Errorf("parsing statusLine: %w", err)
+		}
+	}
+	if !isPresenceStatusLine(current.Command) {
+		change.message = "Statusline is not set up, nothing to do"
+		return change, nil
+	}
+
+	backup, err := os.ReadFile(statusLineBackupPath())
+	switch {
+	case err == nil && json.Valid(backup):
+		settings.setRaw("statusLine", reindent(backup, settings.indent))
+		change.message = "Restored previous statusline"
+	case err == nil || errors.Is(err, os.ErrNotExist):
+		settings.remove("statusLine")
+		change.message = "Removed statusline setting"
+	default:
+		return nil, err
+	}
+	change.removeBackup = err == nil
+	change.after = settings.bytes()
+	return change, nil
+}
+
+// reindent formats a JSON value for nesting one level inside settings
+func reindent(raw []byte, indent string) json.RawMessage {
+	var b bytes.Buffer
+	if err := json.Indent(&b, bytes.TrimSpace(raw), indent, indent); err != nil {
+		return raw
+	}
+	return b.Bytes()
+}
+
+// apply writes the planned change to disk
+func (c *settingsChange) apply() error {
+	if c.backup != nil {
+		var backup bytes.Buffer
+		if err := json.Indent(&backup, bytes.TrimSpace(c.backup), "", "  "); err != nil {
+			return err
+		}
+		backup.WriteByte('\n')
+		if err := writeFileAtomic(statusLineBackupPath(), backup.Bytes(), 0644); err != nil {
+			return fmt.Errorf("backing up statusLine: %w", err)
+		}
+	}
+	if !bytes.Equal(c.before, c.after) {
+		perm := os.FileMode(0644)
+		if info, err := os.Stat(settingsPath()); err == nil {
+			perm = info.Mode().Perm()
+		}
+		if err := os.MkdirAll(claudeDir, 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(settingsPath(), c.after, perm); err != nil {
+			return err
+		}
+	}
+	if c.removeBackup {
+		if err := os.Remove(statusLineBackupPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdSetup(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("setup", &common)
+	dryRun := fs.Bool("dry-run", false, "show the changes to settings.json without writing them")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	command, err := presenceStatusLineCommand()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Cannot determine binary path: %v\n", err)
+		return 1
+	}
+	change, err := planSetup(command)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return finishSettingsChange(change, *dryRun)
+}
+
+func cmdUninstall(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("uninstall", &common)
+	dryRun := fs.Bool("dry-run", false, "show the changes to settings.json without writing them")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+
+	change, err := planUninstall()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	return finishSettingsChange(change, *dryRun)
+}
+
+func finishSettingsChange(change *settingsChange, dryRun bool) int {
+	if dryRun {
+		if bytes.Equal(change.before, change.after) {
+			fmt.Println("No changes to " + settingsPath())
+		} else {
+			fmt.Printf("--- %s\n+++ %s\n", settingsPath(), settingsPath())
+			fmt.Print(lineDiff(string(change.before), string(change.after)))
+		}
+		if change.backup != nil {
+			fmt.Printf("Would back up the current statusLine to %s\n", statusLineBackupPath())
+		}
+		return 0
+	}
+
+	if err := change.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to update %s: %v\n", settingsPath(), err)
+		return 1
+	}
+	if change.backup != nil {
+		fmt.Printf("✓ Backed up previous statusLine to %s\n", statusLineBackupPath())
+	}
+	fmt.Printf("✓ %s\n", change.message)
+	if !bytes.Equal(change.before, change.after) {
+		fmt.Println("Restart Claude Code to apply changes.")
+	}
+	return 0
+}
+
+// lineDiff returns a minimal line-based diff of a and b, with unchanged lines
+// prefixed by a space and changes by - or +
+func lineDiff(a, b string) string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	if a == "" {
+		x = nil
+	}
+
+	// Longest common subsequence table, filled from the end
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			out.WriteString(" " + x[i] + "\n")
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("-" + x[i] + "\n")
+			i++
+		default:
+			out.WriteString("+" + y[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
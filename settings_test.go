@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSettingsRoundTrip tests that untouched settings are written back as-is
+func TestSettingsRoundTrip(t *testing.T) {
+	input := `{
+    "model": "opus",
+    "permissions": {
+        "allow": ["Bash(go test:*)"]
+    },
+    "zeta": 1
+}
+`
+	settings, err := parseSettings([]byte(input))
+	if err != nil {
+		t.Fatalf("parseSettings() error: %v", err)
+	}
+	if got := string(settings.bytes()); got != input {
+		t.Errorf("round trip changed settings:\n%s\nwant:\n%s", got, input)
+	}
+
+	if err := settings.set("statusLine", statusLineSetting{Type: "command", Command: "x statusline"}); err != nil {
+		t.Fatalf("set() error: %v", err)
+	}
+	want := `{
+    "model": "opus",
+    "permissions": {
+        "allow": ["Bash(go test:*)"]
+    },
+    "zeta": 1,
+    "statusLine": {
+        "type": "command",
+        "command": "x statusline"
+    }
+}
+`
+	if got := string(settings.bytes()); got != want {
+		t.Errorf("after set():\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestParseSettingsInvalid tests that non-object settings are rejected
+func TestParseSettingsInvalid(t *testing.T) {
+	for _, input := range []string{`[]`, `{"a": }`, `{"a": 1} {"b": 2}`} {
+		if _, err := parseSettings([]byte(input)); err == nil {
+			t.Errorf("parseSettings(%q) succeeded, want error", input)
+		}
+	}
+}
+
+// TestSetupUninstall tests installing over an existing statusline and restoring it
+func TestSetupUninstall(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	tmpDir := t.TempDir()
+	setClaudeDir(tmpDir)
+
+	original := `{
+  "model": "opus",
+  "statusLine": {
+    "type": "command",
+    "command": "~/bin/prompt.sh"
+  }
+}
+`
+	if err := os.WriteFile(settingsPath(), []byte(original), 0600); err != nil {
+		t.Fatalf("Failed to write settings: %v", err)
+	}
+
+	const command = "/opt/cc-discord-presence statusline"
+	change, err := planSetup(command)
+	if err != nil {
+		t.Fatalf("planSetup() error: %v", err)
+	}
+	if err := change.apply(); err != nil {
+		t.Fatalf("apply() error: %v", err)
+	}
+
+	got, err := statusLineCommand()
+	if err != nil || got != command {
+		t.Errorf("statusLine command = %q (%v), want %q", got, err, command)
+	}
+	if backup := backedUpStatusLine(); backup != "~/bin/prompt.sh" {
+		t.Errorf("backed up command = %q, want %q", backup, "~/bin/prompt.sh")
+	}
+	if info, _ := os.Stat(settingsPath()); info.Mode().Perm() != 0600 {
+		t.Errorf("settings permissions = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Running setup again must not touch anything, including the backup
+	again, err := planSetup(command)
+	if err != nil {
+		t.Fatalf("second planSetup() error: %v", err)
+	}
+	if string(again.before) != string(again.after) || again.backup != nil {
+		t.Error("second setup should be a no-op")
+	}
+
+	change, err = planUninstall()
+	if err != nil {
+		t.Fatalf("planUninstall() error: %v", err)
+	}
+	if err := change.apply(); err != nil {
+		t.Fatalf("apply() error: %v", err)
+	}
+	restored, _ := os.ReadFile(settingsPath())
+	if string(restored) != original {
+		t.Errorf("uninstall left:\n%s\nwant:\n%s", restored, original)
+	}
+	if _, err := os.Stat(statusLineBackupPath()); !os.IsNotExist(err) {
+		t.Error("backup file should be removed after uninstall")
+	}
+}
+
+// TestSetupWithoutExistingStatusLine tests setup and uninstall on fresh settings
+func TestSetupWithoutExistingStatusLine(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(filepath.Join(t.TempDir(), "claude"))
+
+	change, err := planSetup("/opt/cc-discord-presence statusline")
+	if err != nil {
+		t.Fatalf("planSetup() error: %v", err)
+	}
+	if change.backup != nil {
+		t.Error("nothing to back up without an existing statusLine")
+	}
+	if err := change.apply(); err != nil {
+		t.Fatalf("apply() error: %v", err)
+	}
+
+	change, err = planUninstall()
+	if err != nil {
+		t.Fatalf("planUninstall() error: %v", err)
+	}
+	if err := change.apply(); err != nil {
+		t.Fatalf("apply() error: %v", err)
+	}
+
+	data, _ := os.ReadFile(settingsPath())
+	var settings map[string]any
+	if err := json.Unmarshal(data, &settings); err != nil {
+		t.Fatalf("settings not valid JSON: %v", err)
+	}
+	if _, ok := settings["statusLine"]; ok {
+		t.Errorf("statusLine should be removed, got %s", data)
+	}
+}
+
+// TestLineDiff tests the dry-run diff output
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc\n", "a\nB\nc\nd\n")
+	want := strings.Join([]string{" a", "-b", "+B", " c", "+d", ""}, "\n")
+	if got != want {
+		t.Errorf("lineDiff() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// TestStatusLineCommandFor tests quoting the binary path for the shell the
+// statusLine runs in
+func TestStatusLineCommandFor(t *testing.T) {
+	tests := []struct {
+		exe  string
+		goos string
+		want string
+	}{
+		{"/opt/cc-discord-presence", "linux", "/opt/cc-discord-presence statusline"},
+		{"/Users/me/My Apps/cc-discord-presence", "darwin", "'/Users/me/My Apps/cc-discord-presence' statusline"},
+		{"/home/o'brien/cc-discord-presence", "linux", `'/home/o'\''brien/cc-discord-presence' statusline`},
+		{`C:\Users\me\bin\cc-discord-presence.exe`, "windows", `C:\Users\me\bin\cc-discord-presence.exe statusline`},
+		{`C:\Program Files\cc-discord-presence\cc-discord-presence.exe`, "windows", `"C:\Program Files\cc-discord-presence\cc-discord-presence.exe" statusline`},
+	}
+
+	for _, tt := range tests {
+		if got := statusLineCommandFor(tt.exe, tt.goos); got != tt.want {
+			t.Errorf("statusLineCommandFor(%q, %s) = %s, want %s", tt.exe, tt.goos, got, tt.want)
+		}
+		if exe, args := splitStatusLineCommand(tt.want); exe != tt.exe || args != "statusline" {
+			t.Errorf("splitStatusLineCommand(%s) = %q, %q, want %q, statusline", tt.want, exe, args, tt.exe)
+		}
+	}
+}
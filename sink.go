@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tsanva/cc-discord-presence/discord"
+)
+
+// Presence is what the daemon shows. Sinks with their own templates render
+// Data; it is a snapshot, so sinks can use it from their own goroutine.
+type Presence struct {
+	Activity discord.Activity
+	Data     presenceData
+	// Override is the active override, if any
+	Override *presenceOverride
+}
+
+// Sink is an output the presence is shown on, such as Discord
+type Sink interface {
+	// Name identifies the sink in logs and metrics
+	Name() string
+	// Update shows a new presence
+	Update(p Presence) error
+	// Clear hides the presence until the next update
+	Clear() error
+	// Close releases the sink; it is not used afterwards
+	Close() error
+}
+
+// errSinkOffline is returned by a sink that cannot show the presence right
+// now but catches up by itself, so it is not logged as a failure
+var errSinkOffline = errors.New("not connected")
+
+// sinkOp is an update or clear waiting to be applied to a sink
+type sinkOp struct {
+	clear    bool
+	presence Presence
+}
+
+// sinkRunner applies operations to one sink from its own goroutine, so a
+// slow or failing sink does not hold up the others. Only the latest
+// operation is kept: a sink that falls behind skips to the current presence.
+type sinkRunner struct {
+	sink Sink
+	// name and config are the sink's name and settings in the config, to
+	// tell on reload whether it changed
+	name   string
+	config any
+
+	mu      sync.Mutex
+	pending *sinkOp
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newSinkRunner(s Sink) *sinkRunner {
+	r := &sinkRunner{
+		sink:    s,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if sup, ok := s.(superseder); ok {
+		sup.setSuperseded(r.hasPending)
+	}
+	go r.run()
+	return r
+}
+
+// submit replaces any pending operation with op
+func (r *sinkRunner) submit(op sinkOp) {
+	r.mu.Lock()
+	r.pending = &op
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// hasPending reports whether an operation is waiting behind the one being
+// applied
+func (r *sinkRunner) hasPending() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pending != nil
+}
+
+func (r *sinkRunner) run() {
+	defer close(r.stopped)
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.wake:
+		}
+
+		r.mu.Lock()
+		op := r.pending
+		r.pending = nil
+		r.mu.Unlock()
+		if op == nil {
+			continue
+		}
+
+		var err error
+		if op.clear {
+			err = r.sink.Clear()
+		} else {
+			err = r.sink.Update(op.presence)
+		}
+		metrics.observeUpdate(r.sink.Name(), err)
+		if errors.Is(err, errSinkOffline) {
+			slog.Debug("Sink is offline, update held back", "sink", r.sink.Name(), "err", err)
+		} else if err != nil {
+			slog.Error("Failed to update presence", "sink", r.sink.Name(), "err", err)
+		}
+	}
+}
+
+// interrupter is a sink that can cut short an operation in progress, such
+// as a retry backoff, so closing it does not have to wait
+type interrupter interface {
+	interrupt()
+}
+
+// superseder is a sink that holds an operation back, such as for a rate
+// limit, and drops it if a newer one is waiting by then. Its runner passes
+// the check for that.
+type superseder interface {
+	setSuperseded(func() bool)
+}
+
+// close stops the runner, dropping any pending operation, and closes the sink
+func (r *sinkRunner) close() error {
+	close(r.done)
+	if i, ok := r.sink.(interrupter); ok {
+		i.interrupt()
+	}
+	<-r.stopped
+	return r.sink.Close()
+}
+
+// sinkSet is every sink the daemon shows the presence on
+type sinkSet []*sinkRunner
+
+// sinks is the daemon's current set, replaced under daemonMu on reload
+var sinks sinkSet
+
+func (s sinkSet) update(p Presence) {
+	for _, r := range s {
+		r.submit(sinkOp{presence: p})
+	}
+}
+
+func (s sinkSet) clear() {
+	for _, r := range s {
+		r.submit(sinkOp{clear: true})
+	}
+}
+
+func (s sinkSet) close() {
+	for _, r := range s {
+		if err := r.close(); err != nil {
+			slog.Debug("Failed to close sink", "sink", r.sink.Name(), "err", err)
+		}
+	}
+}
+
+// sinkSpec is a sink enabled in the config: its name, the settings it is
+// opened with and how to open it
+type sinkSpec struct {
+	name   string
+	config any
+	open   func() (Sink, error)
+}
+
+// sinkSpecs lists the sinks the config enables
+func sinkSpecs(c *Config) []sinkSpec {
+	var specs []sinkSpec
+	if c.Sinks.Discord.Enabled {
+		d := c.Sinks.Discord
+		specs = append(specs, sinkSpec{"discord", struct {
+			ClientID string
+			DiscordSinkConfig
+		}{c.ClientID, d}, func() (Sink, error) {
+			return newDiscordSink(c.ClientID, d)
+		}})
+	}
+	if c.Sinks.Slack.Enabled {
+		sc := c.Sinks.Slack
+		specs = append(specs, sinkSpec{"slack", sc, func() (Sink, error) {
+			return newSlackSink(sc)
+		}})
+	}
+	if c.Sinks.Overlay.Enabled {
+		oc := c.Sinks.Overlay
+		specs = append(specs, sinkSpec{"overlay", oc, func() (Sink, error) {
+			return newOverlaySink(localAddr(oc.Listen))
+		}})
+	}
+	if c.Sinks.DBus.Enabled {
+		specs = append(specs, sinkSpec{"dbus", c.Sinks.DBus, newDBusSink})
+	}
+	for i, w := range c.Sinks.Webhooks {
+		name := "webhook-" + strconv.Itoa(i+1)
+		if w.Name != "" {
+			name = "webhook-" + w.Name
+		}
+		specs = append(specs, sinkSpec{name, w, func() (Sink, error) {
+			return newWebhookSink(name, w)
+		}})
+	}
+	return specs
+}
+
+// openSinks opens every sink enabled in the config. A sink that fails to
+// open is logged and left out, so one broken sink does not keep the
+// presence off the others.
+func openSinks(c *Config) sinkSet {
+	return reopenSinks(nil, c)
+}
+
+// reopenSinks switches from the sinks in current to those next enables. A
+// sink whose settings did not change is kept as it is, so changing one
+// webhook does not reconnect Discord or drop the overlay's viewers. The
+// sinks that changed or were disabled are closed first, since sinks like
+// the overlay hold a port.
+func reopenSinks(current sinkSet, next *Config) sinkSet {
+	specs := sinkSpecs(next)
+	kept := make([]*sinkRunner, len(specs))
+	claimed := map[*sinkRunner]bool{}
+	for i, spec := range specs {
+		for _, r := range current {
+			if !claimed[r] && r.name == spec.name && reflect.DeepEqual(r.config, spec.config) {
+				kept[i], claimed[r] = r, true
+				break
+			}
+		}
+	}
+	for _, r := range current {
+		if !claimed[r] {
+			if err := r.close(); err != nil {
+				slog.Debug("Failed to close sink", "sink", r.sink.Name(), "err", err)
+			}
+		}
+	}
+
+	var set sinkSet
+	for i, spec := range specs {
+		if kept[i] != nil {
+			set = append(set, kept[i])
+			continue
+		}
+		s, err := spec.open()
+		if err != nil {
+			slog.Error("Failed to open presence sink", "sink", spec.name, "err", err)
+			continue
+		}
+		r := newSinkRunner(s)
+		r.name, r.config = spec.name, spec.config
+		set = append(set, r)
+	}
+	return set
+}
+
+// closeSinks closes the daemon's sinks on shutdown
+func closeSinks() {
+	daemonMu.Lock()
+	defer daemonMu.Unlock()
+	sinks.close()
+	sinks = nil
+}
+
+// discordTimeout bounds every call to Discord, so a Discord that stopped
+// responding cannot hang the daemon
+const discordTimeout = 10 * time.Second
+
+// Discord reconnect backoff: the first retry waits discordBackoff, then the
+// wait doubles up to discordMaxBackoff
+const (
+	discordBackoff    = 5 * time.Second
+	discordMaxBackoff = time.Minute
+)
+
+// errDiscordOffline is returned for updates while the sink is reconnecting
+var errDiscordOffline = fmt.Errorf("%w: the presence is shown once Discord reconnects", errSinkOffline)
+
+// discordSink shows the presence as Discord Rich Presence. It reconnects
+// whenever the connection is lost, such as when Discord restarts, and shows
+// the latest presence again once it is back.
+type discordSink struct {
+	clientID string
+	opts     discord.Options
+	// ctx is cancelled when the sink closes, ending any call in progress
+	// and the reconnect loop
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	mu sync.Mutex
+	// client is nil while reconnecting
+	client *discord.Client
+	// last is the latest operation, shown again after reconnecting
+	last *sinkOp
+	// seq numbers the operations, so a replay that lost the race with a
+	// newer one is dropped
+	seq uint64
+
+	// showMu is held while an operation is sent to Discord, so a replay and
+	// a new operation cannot cross on the way
+	showMu sync.Mutex
+}
+
+// options returns the Discord client options for this config
+func (c DiscordSinkConfig) options() discord.Options {
+	return discord.Options{IPCPath: expandHome(c.IPCPath), Prefer: c.Prefer}
+}
+
+// newDiscordSink opens the Discord sink. It connects in the background, so
+// opening it never waits for Discord, and keeps trying while Discord is not
+// running.
+func newDiscordSink(clientID string, c DiscordSinkConfig) (Sink, error) {
+	d := &discordSink{
+		clientID: clientID,
+		opts:     c.options(),
+		stopped:  make(chan struct{}),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	go d.run()
+	return d, nil
+}
+
+// connect makes one attempt to connect to Discord
+func (d *discordSink) connect() (*discord.Client, error) {
+	client := discord.NewClientWithOptions(d.clientID, d.opts)
+	ctx, cancel := context.WithTimeout(d.ctx, discordTimeout)
+	defer cancel()
+	if err := client.ConnectContext(ctx); err != nil {
+		return nil, err
+	}
+	slog.Info("Connected to Discord", "user", client.User().Tag(), "build", client.Build(), "ipc_path", client.IPCPath())
+	return client, nil
+}
+
+// run keeps the sink connected until it closes
+func (d *discordSink) run() {
+	defer close(d.stopped)
+	backoff := discordBackoff
+	for attempt := 0; ; attempt++ {
+		client, err := d.connect()
+		switch {
+		case d.ctx.Err() != nil:
+			return
+		case err != nil && attempt == 0:
+			slog.Warn("Failed to connect to Discord, retrying in the background", "client_id", d.clientID, "err", err)
+		case err != nil:
+			slog.Debug("Failed to reconnect to Discord", "in", backoff, "err", err)
+		default:
+			backoff = discordBackoff
+			d.use(client)
+			select {
+			case <-d.ctx.Done():
+				return
+			case <-client.Done():
+			}
+			d.use(nil)
+			slog.Warn("Lost connection to Discord, reconnecting", "err", client.Err())
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if err != nil {
+			backoff = min(backoff*2, discordMaxBackoff)
+		}
+	}
+}
+
+// use switches to client, or to reconnecting if it is nil, and shows the
+// latest presence on a new connection
+func (d *discordSink) use(client *discord.Client) {
+	d.mu.Lock()
+	d.client = client
+	last, seq := d.last, d.seq
+	d.mu.Unlock()
+
+	metrics.setDiscordConnected(client != nil)
+	if client == nil || last == nil {
+		return
+	}
+	if err := d.show(client, *last, seq); err != nil {
+		slog.Error("Failed to update presence", "sink", d.Name(), "err", err)
+	}
+}
+
+// show applies op on client unless a newer operation than seq was submitted
+// since, which is then shown instead
+func (d *discordSink) show(client *discord.Client, op sinkOp, seq uint64) error {
+	d.showMu.Lock()
+	defer d.showMu.Unlock()
+
+	d.mu.Lock()
+	stale := seq != d.seq
+	d.mu.Unlock()
+	if stale {
+		return nil
+	}
+	return d.apply(client, op)
+}
+
+// apply shows op on client
+func (d *discordSink) apply(client *discord.Client, op sinkOp) error {
+	ctx, cancel := context.WithTimeout(d.ctx, discordTimeout)
+	defer cancel()
+	if op.clear {
+		return client.ClearActivityContext(ctx)
+	}
+	return client.SetActivityContext(ctx, op.presence.Activity)
+}
+
+// submit records op as the latest and shows it if Discord is connected
+func (d *discordSink) submit(op sinkOp) error {
+	d.mu.Lock()
+	d.last = &op
+	d.seq++
+	client, seq := d.client, d.seq
+	d.mu.Unlock()
+
+	if client == nil {
+		return errDiscordOffline
+	}
+	return d.show(client, op, seq)
+}
+
+// connected returns the current client, or nil while reconnecting
+func (d *discordSink) connected() *discord.Client {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.client
+}
+
+// discordAccount returns the Discord user the sinks show the presence to and
+// their Discord build, if a Discord sink is connected. Callers must hold
+// daemonMu.
+func (s sinkSet) discordAccount() (user, build string) {
+	for _, r := range s {
+		if d, ok := r.sink.(*discordSink); ok {
+			if client := d.connected(); client != nil {
+				return client.User().Tag(), client.Build()
+			}
+		}
+	}
+	return "", ""
+}
+
+func (d *discordSink) Name() string {
+	return "discord"
+}
+
+func (d *discordSink) Update(p Presence) error {
+	return d.submit(sinkOp{presence: p})
+}
+
+func (d *discordSink) Clear() error {
+	return d.submit(sinkOp{clear: true})
+}
+
+// interrupt cuts short a call to a Discord that stopped responding, so
+// shutting down does not wait for it
+func (d *discordSink) interrupt() {
+	d.cancel()
+}
+
+func (d *discordSink) Close() error {
+	d.cancel()
+	<-d.stopped
+	metrics.setDiscordConnected(false)
+	if client := d.connected(); client != nil {
+		return client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tsanva/cc-discord-presence/discord"
+)
+
+// testSink records what it was sent. Updates block while gate is set and
+// fail while err is set.
+type testSink struct {
+	name string
+	err  error
+	gate chan struct{}
+
+	mu      sync.Mutex
+	got     []string
+	closed  bool
+	applied chan struct{}
+}
+
+func newTestSink(name string) *testSink {
+	return &testSink{name: name, applied: make(chan struct{}, 100)}
+}
+
+func (s *testSink) Name() string { return s.name }
+
+func (s *testSink) Update(p Presence) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	return s.record(p.Activity.Details)
+}
+
+func (s *testSink) Clear() error { return s.record("clear") }
+
+func (s *testSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *testSink) record(op string) error {
+	s.mu.Lock()
+	s.got = append(s.got, op)
+	s.mu.Unlock()
+	s.applied <- struct{}{}
+	return s.err
+}
+
+func (s *testSink) ops() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.got...)
+}
+
+// wait blocks until the sink applied n more operations
+func (s *testSink) wait(t *testing.T, n int) {
+	t.Helper()
+	for range n {
+		select {
+		case <-s.applied:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sink %s applied %v, timed out waiting for more", s.name, s.ops())
+		}
+	}
+}
+
+func presenceWith(details string) Presence {
+	return Presence{Activity: discord.Activity{Details: details}}
+}
+
+// TestSinkRunnerLatestWins tests that a slow sink skips to the latest presence
+func TestSinkRunnerLatestWins(t *testing.T) {
+	sink := newTestSink("slow")
+	sink.gate = make(chan struct{})
+	r := newSinkRunner(sink)
+
+	r.submit(sinkOp{presence: presenceWith("first")})
+	// Wait for the runner to pick up the first update before queueing more
+	for {
+		r.mu.Lock()
+		picked := r.pending == nil
+		r.mu.Unlock()
+		if picked {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r.submit(sinkOp{presence: presenceWith("second")})
+	r.submit(sinkOp{presence: presenceWith("third")})
+	close(sink.gate)
+	sink.wait(t, 2)
+
+	if err := r.close(); err != nil {
+		t.Fatalf("close() error: %v", err)
+	}
+	got := sink.ops()
+	if len(got) != 2 || got[0] != "first" || got[1] != "third" {
+		t.Errorf("sink got %v, want [first third]", got)
+	}
+	if !sink.closed {
+		t.Error("close() did not close the sink")
+	}
+}
+
+// TestSinkSetIsolation tests that a failing or stuck sink does not hold up
+// the others
+func TestSinkSetIsolation(t *testing.T) {
+	origMetrics := metrics
+	defer func() { metrics = origMetrics }()
+	metrics = &daemonMetrics{}
+
+	stuck := newTestSink("stuck")
+	stuck.gate = make(chan struct{})
+	failing := newTestSink("failing")
+	failing.err = errors.New("offline")
+	healthy := newTestSink("healthy")
+
+	set := sinkSet{newSinkRunner(stuck), newSinkRunner(failing), newSinkRunner(healthy)}
+	set.update(presenceWith("working"))
+	healthy.wait(t, 1)
+	failing.wait(t, 1)
+	set.clear()
+	healthy.wait(t, 1)
+	failing.wait(t, 1)
+
+	if got := healthy.ops(); len(got) != 2 || got[0] != "working" || got[1] != "clear" {
+		t.Errorf("healthy sink got %v, want [working clear]", got)
+	}
+	if got := failing.ops(); len(got) != 2 {
+		t.Errorf("failing sink got %v, want every operation despite errors", got)
+	}
+	if got := stuck.ops(); len(got) != 0 {
+		t.Errorf("stuck sink got %v, want nothing yet", got)
+	}
+
+	close(stuck.gate)
+	set.close()
+}
+
+// TestOpenSinksSkipsFailures tests that a sink failing to open leaves the
+// others running
+func TestOpenSinksSkipsFailures(t *testing.T) {
+	// Hold the overlay's port so it cannot listen
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	c := defaultConfig()
+	c.Sinks.Discord.Enabled = false
+	c.Sinks.Overlay = OverlaySinkConfig{Enabled: true, Listen: taken.Addr().String()}
+	c.Sinks.Webhooks = []WebhookSinkConfig{{Name: "ok", URL: "http://127.0.0.1:1/hook"}}
+
+	set := openSinks(c)
+	defer set.close()
+	if len(set) != 1 || set[0].sink.Name() != "webhook-ok" {
+		var names []string
+		for _, r := range set {
+			names = append(names, r.sink.Name())
+		}
+		t.Errorf("openSinks() opened %v, want only webhook-ok", names)
+	}
+}
+
+// TestReopenSinks tests that a reload only reopens the sinks whose settings
+// changed
+func TestReopenSinks(t *testing.T) {
+	c := defaultConfig()
+	c.Sinks.Discord.Enabled = false
+	c.Sinks.Overlay = OverlaySinkConfig{Enabled: true, Listen: "127.0.0.1:0"}
+	c.Sinks.Webhooks = []WebhookSinkConfig{
+		{Name: "a", URL: "http://127.0.0.1:1/a"},
+		{Name: "b", URL: "http://127.0.0.1:1/b"},
+	}
+	set := openSinks(c)
+	defer func() { set.close() }()
+	byName := func(set sinkSet) map[string]*sinkRunner {
+		runners := map[string]*sinkRunner{}
+		for _, r := range set {
+			runners[r.name] = r
+		}
+		return runners
+	}
+	before := byName(set)
+	if len(before) != 3 {
+		t.Fatalf("openSinks() opened %d sinks, want 3", len(before))
+	}
+
+	next := *c
+	next.Sinks.Webhooks = []WebhookSinkConfig{
+		{Name: "a", URL: "http://127.0.0.1:1/a"},
+		{Name: "b", URL: "http://127.0.0.1:1/changed"},
+	}
+	set = reopenSinks(set, &next)
+	after := byName(set)
+	if after["overlay"] != before["overlay"] || after["webhook-a"] != before["webhook-a"] {
+		t.Error("unchanged sinks should be kept open")
+	}
+	if after["webhook-b"] == nil || after["webhook-b"] == before["webhook-b"] {
+		t.Error("the changed webhook should be reopened")
+	}
+	select {
+	case <-before["webhook-b"].stopped:
+	default:
+		t.Error("the changed webhook's old runner should be closed")
+	}
+
+	// A disabled sink is closed, freeing its port
+	overlay := before["overlay"]
+	next.Sinks.Overlay.Enabled = false
+	set = reopenSinks(set, &next)
+	if _, ok := byName(set)["overlay"]; ok || len(set) != 2 {
+		t.Errorf("reopenSinks() kept %d sinks, want the overlay closed", len(set))
+	}
+	select {
+	case <-overlay.stopped:
+	default:
+		t.Error("the disabled overlay should be closed")
+	}
+}
+
+// TestDiscordSinkDropsStaleReplay tests that replaying the latest presence
+// after a reconnect gives way to an update submitted in the meantime
+func TestDiscordSinkDropsStaleReplay(t *testing.T) {
+	d := &discordSink{}
+	d.submit(sinkOp{presence: presenceWith("old")})
+	replay, seq := *d.last, d.seq
+	d.submit(sinkOp{presence: presenceWith("new")})
+
+	// A nil client would panic if the stale replay reached Discord
+	if err := d.show(nil, replay, seq); err != nil {
+		t.Errorf("show() stale replay error: %v", err)
+	}
+}
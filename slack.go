@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// slackAPIURL is the Slack Web API
+const slackAPIURL = "https://slack.com/api"
+
+// defaultSlackTextTemplate is the default Slack status text
+const defaultSlackTextTemplate = "Pairing with {{.ModelName}} on {{.ProjectName}}"
+
+// maxSlackStatusText is the longest status text Slack accepts
+const maxSlackStatusText = 100
+
+// slackStatus is the part of a Slack profile the sink sets
+type slackStatus struct {
+	Text       string `json:"status_text"`
+	Emoji      string `json:"status_emoji"`
+	Expiration int64  `json:"status_expiration"`
+}
+
+// slackSink shows the presence as the Slack status of the token's user.
+// The status is only sent when it changes, or to push the expiration back
+// once half of it has passed.
+type slackSink struct {
+	c      SlackSinkConfig
+	tmpl   *template.Template
+	client *http.Client
+	now    func() time.Time
+
+	// last is the status we set and when; nothing is cleared unless set
+	last  slackStatus
+	setAt time.Time
+	set   bool
+	// retryAt holds back updates after Slack rate limited us
+	retryAt time.Time
+}
+
+func newSlackSink(c SlackSinkConfig) (Sink, error) {
+	tmpl, err := parseTemplate("sinks.slack.text", c.Text)
+	if err != nil {
+		return nil, err
+	}
+	return &slackSink{
+		c:      c,
+		tmpl:   tmpl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}, nil
+}
+
+func (s *slackSink) Name() string {
+	return "slack"
+}
+
+func (s *slackSink) Update(p Presence) error {
+	text, err := renderTemplate(s.tmpl, p.Data)
+	if err != nil {
+		return err
+	}
+	// An override replaces the status text too
+	if o := p.Override; o != nil {
+		text = o.Details
+		if text == "" {
+			text = o.State
+		}
+	}
+	status := slackStatus{Text: truncateText(text, maxSlackStatusText), Emoji: s.c.Emoji}
+
+	now := s.now()
+	if now.Before(s.retryAt) {
+		// The next update after the wait sends the current status
+		return nil
+	}
+	expiration := time.Duration(s.c.Expiration)
+	if s.set && status.Text == s.last.Text && status.Emoji == s.last.Emoji &&
+		(expiration == 0 || now.Sub(s.setAt) < expiration/2) {
+		return nil
+	}
+	if expiration > 0 {
+		status.Expiration = now.Add(expiration).Unix()
+	}
+
+	if err := s.setStatus(status); err != nil {
+		return err
+	}
+	s.last, s.setAt, s.set = status, now, true
+	return nil
+}
+
+func (s *slackSink) Clear() error {
+	if !s.set {
+		return nil
+	}
+	if err := s.setStatus(slackStatus{}); err != nil {
+		return err
+	}
+	s.last, s.set = slackStatus{}, false
+	return nil
+}
+
+// Close clears the status, so it does not outlive the daemon
+func (s *slackSink) Close() error {
+	return s.Clear()
+}
+
+// setStatus calls users.profile.set
+func (s *slackSink) setStatus(status slackStatus) error {
+	body, err := json.Marshal(map[string]any{"profile": status})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(s.c.APIURL, "/") + "/users.profile.set"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.c.Token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil {
+			wait = 60
+		}
+		s.retryAt = s.now().Add(time.Duration(wait) * time.Second)
+		return fmt.Errorf("rate limited, retrying after %ds", wait)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("users.profile.set returned %s", resp.Status)
+	}
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("reading users.profile.set response: %w", err)
+	}
+	if !result.OK {
+		return fmt.Errorf("users.profile.set failed: %s", result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slackStandIn records users.profile.set calls and answers with reply
+type slackStandIn struct {
+	mu       sync.Mutex
+	statuses []slackStatus
+	reply    string
+	code     int
+}
+
+func (s *slackStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/users.profile.set" || r.Header.Get("Authorization") != "Bearer xoxp-test" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var body struct {
+		Profile slackStatus `json:"profile"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = append(s.statuses, body.Profile)
+	if s.code != 0 {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(s.code)
+		return
+	}
+	reply := s.reply
+	if reply == "" {
+		reply = `{"ok": true}`
+	}
+	w.Write([]byte(reply))
+}
+
+func (s *slackStandIn) calls() []slackStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slackStatus(nil), s.statuses...)
+}
+
+func newTestSlackSink(t *testing.T, standIn *slackStandIn, now *time.Time) *slackSink {
+	t.Helper()
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	c := defaultConfig().Sinks.Slack
+	c.Enabled, c.Token, c.APIURL = true, "xoxp-test", server.URL
+	sink, err := newSlackSink(c)
+	if err != nil {
+		t.Fatalf("newSlackSink() error: %v", err)
+	}
+	s := sink.(*slackSink)
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func slackPresence(project string) Presence {
+	return Presence{Data: presenceData{SessionData: &SessionData{ProjectName: project, ModelName: "Opus 4.5"}}}
+}
+
+// TestSlackSinkUpdate tests setting, refreshing and clearing the status
+func TestSlackSinkUpdate(t *testing.T) {
+	standIn := &slackStandIn{}
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	s := newTestSlackSink(t, standIn, &now)
+
+	if err := s.Clear(); err != nil || len(standIn.calls()) != 0 {
+		t.Fatalf("Clear() before any update = %v with %d calls, want no call", err, len(standIn.calls()))
+	}
+
+	if err := s.Update(slackPresence("api-server")); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	want := slackStatus{Text: "Pairing with Opus 4.5 on api-server", Emoji: ":robot_face:", Expiration: now.Add(30 * time.Minute).Unix()}
+	if got := standIn.calls(); len(got) != 1 || got[0] != want {
+		t.Fatalf("status = %+v, want %+v", got, want)
+	}
+
+	// The same status is not sent again until half the expiration passed
+	now = now.Add(10 * time.Minute)
+	s.Update(slackPresence("api-server"))
+	if n := len(standIn.calls()); n != 1 {
+		t.Errorf("unchanged status sent again, %d calls", n)
+	}
+	now = now.Add(6 * time.Minute)
+	s.Update(slackPresence("api-server"))
+	if got := standIn.calls(); len(got) != 2 || got[1].Expiration != now.Add(30*time.Minute).Unix() {
+		t.Errorf("status was not refreshed before expiring: %+v", got)
+	}
+
+	// An override replaces the text
+	p := slackPresence("api-server")
+	p.Override = &presenceOverride{State: "In a meeting"}
+	s.Update(p)
+	if got := standIn.calls(); got[len(got)-1].Text != "In a meeting" {
+		t.Errorf("override status text = %q, want %q", got[len(got)-1].Text, "In a meeting")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if got := standIn.calls(); got[len(got)-1] != (slackStatus{}) {
+		t.Errorf("Close() sent %+v, want a cleared status", got[len(got)-1])
+	}
+}
+
+// TestSlackSinkErrors tests Slack API errors and rate limiting
+func TestSlackSinkErrors(t *testing.T) {
+	standIn := &slackStandIn{reply: `{"ok": false, "error": "invalid_auth"}`}
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	s := newTestSlackSink(t, standIn, &now)
+
+	if err := s.Update(slackPresence("app")); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Errorf("Update() error = %v, want invalid_auth", err)
+	}
+	// A failed update is retried with the next one
+	standIn.reply = ""
+	if err := s.Update(slackPresence("app")); err != nil || len(standIn.calls()) != 2 {
+		t.Errorf("Update() after a failure = %v with %d calls, want a retry", err, len(standIn.calls()))
+	}
+
+	standIn.code = http.StatusTooManyRequests
+	if err := s.Update(slackPresence("other")); err == nil {
+		t.Error("Expected error when rate limited")
+	}
+	calls := len(standIn.calls())
+	now = now.Add(10 * time.Second)
+	if err := s.Update(slackPresence("other")); err != nil || len(standIn.calls()) != calls {
+		t.Errorf("Update() while rate limited = %v, sent %d calls, want none", err, len(standIn.calls())-calls)
+	}
+	standIn.code = 0
+	now = now.Add(30 * time.Second)
+	if err := s.Update(slackPresence("other")); err != nil || len(standIn.calls()) != calls+1 {
+		t.Errorf("Update() after the wait = %v, want the status sent", err)
+	}
+}
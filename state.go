@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DaemonState is written by the running daemon so that `status` can report
+// on it from another process
+type DaemonState struct {
+	PID         int       `json:"pid"`
+	Version     string    `json:"version"`
+	StartedAt   time.Time `json:"started_at"`
+	DataSource  string    `json:"data_source,omitempty"`
+	Project     string    `json:"project,omitempty"`
+	GitBranch   string    `json:"git_branch,omitempty"`
+	Model       string    `json:"model,omitempty"`
+	TotalTokens int64     `json:"total_tokens,omitempty"`
+	TotalCost   float64   `json:"total_cost,omitempty"`
+	LastUpdate  time.Time `json:"last_update,omitempty"`
+
+	// DiscordUser is the account the presence is shown to, as @username
+	DiscordUser  string `json:"discord_user,omitempty"`
+	DiscordBuild string `json:"discord_build,omitempty"`
+
+	Paused   bool              `json:"paused"`
+	Quiet    bool              `json:"quiet"`
+	Override *presenceOverride `json:"override,omitempty"`
+}
+
+// Data source names reported in the daemon state
+const (
+	sourceStatusLine = "statusline"
+	sourceJSONL      = "jsonl"
+)
+
+var daemonState DaemonState
+
+func pidFilePath() string {
+	return filepath.Join(claudeDir, "discord-presence.pid")
+}
+
+func stateFilePath() string {
+	return filepath.Join(claudeDir, "discord-presence-state.json")
+}
+
+// writeFileAtomic writes data to a temp file and renames it over path, so
+// readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return replaceFile(path, data, perm, false)
+}
+
+// writeFileDurable is writeFileAtomic for data that must survive a power
+// loss, syncing the new contents before they replace the old file
+func writeFileDurable(path string, data []byte, perm os.FileMode) error {
+	return replaceFile(path, data, perm, true)
+}
+
+func replaceFile(path string, data []byte, perm os.FileMode, sync bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if sync {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writePIDFile() error {
+	return writeFileAtomic(pidFilePath(), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// readPIDFile returns the daemon PID, or 0 if there is no usable PID file
+func readPIDFile() int {
+	data, err := os.ReadFile(pidFilePath())
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// currentDaemonState returns the state with the live pause and override
+// settings filled in. Callers must hold daemonMu.
+func currentDaemonState() DaemonState {
+	state := daemonState
+	state.Paused = paused
+	state.Quiet = quiet
+	state.DiscordUser, state.DiscordBuild = sinks.discordAccount()
+	if override.active() {
+		state.Override = override
+	}
+	return state
+}
+
+func saveDaemonState() error {
+	data, err := json.MarshalIndent(currentDaemonState(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(stateFilePath(), data, 0644)
+}
+
+func readDaemonState() (*DaemonState, error) {
+	data, err := os.ReadFile(stateFilePath())
+	if err != nil {
+		return nil, err
+	}
+	var state DaemonState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// recordUpdate stores the session that was just pushed to Discord
+func recordUpdate(session *SessionData) {
+	daemonState.DataSource = sourceStatusLine
+	if usingFallback {
+		daemonState.DataSource = sourceJSONL
+	}
+	daemonState.Project = session.ProjectName
+	daemonState.GitBranch = session.GitBranch
+	daemonState.Model = session.ModelName
+	daemonState.TotalTokens = session.TotalTokens
+	daemonState.TotalCost = session.TotalCost
+	daemonState.LastUpdate = time.Now()
+
+	if err := saveDaemonState(); err != nil {
+		slog.Error("Failed to save daemon state", "err", err)
+	}
+}
+
+// cleanupDaemonFiles removes the PID and state files on shutdown
+func cleanupDaemonFiles() {
+	if readPIDFile() == os.Getpid() {
+		os.Remove(pidFilePath())
+	}
+	os.Remove(stateFilePath())
+	os.Remove(controlSocketPath())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// legacyStatusLinePath is where setup-statusline.sh backed up an existing
+// statusline script; it is chained by default so old setups keep working
+func legacyStatusLinePath() string {
+	return filepath.Join(claudeDir, "statusline.sh")
+}
+
+// cmdStatusLine is run by Claude Code as its statusLine command. It saves the
+// JSON it receives on stdin for the daemon, prints the configured statusline
+// text and then hands the same JSON to the user's original statusline.
+func cmdStatusLine(args []string) int {
+	var common commonFlags
+	fs := newFlagSet("statusline", &common)
+	format := fs.String("format", "", "template for the statusline text (overrides statusline.format)")
+	chain := fs.String("chain", "", "command to pass the statusline JSON on to (overrides statusline.chain)")
+	if code, ok := parseCommand(fs, &common, args); !ok {
+		return code
+	}
+	if *format != "" {
+		cfg.StatusLine.Format = *format
+	}
+	if *chain != "" {
+		cfg.StatusLine.Chain = *chain
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading statusline data: %v\n", err)
+		return 1
+	}
+
+	// Never let a problem on our side break the user's statusline: report it
+	// on stderr and still run the chained command
+	if err := saveStatusLineData(data); err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving statusline data: %v\n", err)
+	}
+
+	if cfg.StatusLine.Format != "" {
+		if err := printStatusLine(os.Stdout, data); err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering statusline: %v\n", err)
+		}
+	}
+
+	command := statusLineChain()
+	if command == "" {
+		return 0
+	}
+	return runChained(command, data)
+}
+
+// saveStatusLineData validates and atomically writes the statusline JSON to
+// the file the daemon watches
+func saveStatusLineData(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		return fmt.Errorf("stdin is not valid JSON")
+	}
+	return writeFileAtomic(dataFilePath, data, 0644)
+}
+
+func printStatusLine(w io.Writer, data []byte) error {
+	session := parseStatusLineData(data)
+	if session == nil {
+		return fmt.Errorf("no session in statusline data")
+	}
+	tmpl, err := parseTemplate("statusline.format", cfg.StatusLine.Format)
+	if err != nil {
+		return err
+	}
+	text, err := renderTemplate(tmpl, session)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, text)
+	return err
+}
+
+// statusLineChain returns the command to chain to, if any: the configured
+// one, else the statusLine that setup replaced, else the legacy script
+func statusLineChain() string {
+	if cfg.StatusLine.Chain != "" {
+		return cfg.StatusLine.Chain
+	}
+	if command := backedUpStatusLine(); command != "" {
+		return command
+	}
+	if info, err := os.Stat(legacyStatusLinePath()); err == nil && isExecutable(info) {
+		return legacyStatusLinePath()
+	}
+	return ""
+}
+
+// runChained runs the chained statusline command with the original JSON on
+// stdin and returns its exit code as ours
+func runChained(command string, data []byte) int {
+	cmd := shellCommand(command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	default:
+		fmt.Fprintf(os.Stderr, "Error running statusline command %q: %v\n", command, err)
+		return 1
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+const testStatusLineJSON = `{
+	"session_id": "abc123",
+	"cwd": "/Users/test/myproject",
+	"model": {"id": "claude-opus-4-5-20251101", "display_name": "Opus 4.5"},
+	"workspace": {"project_dir": "/Users/test/myproject"},
+	"cost": {"total_cost_usd": 0.5},
+	"context_window": {"total_input_tokens": 10000, "total_output_tokens": 5000}
+}`
+
+// TestSaveStatusLineData tests persisting statusline JSON for the daemon
+func TestSaveStatusLineData(t *testing.T) {
+	origDataFilePath := dataFilePath
+	defer func() { dataFilePath = origDataFilePath }()
+	dataFilePath = filepath.Join(t.TempDir(), "discord-presence-data.json")
+
+	// Multi-line JSON is what broke the old `read -r` wrapper
+	if err := saveStatusLineData([]byte(testStatusLineJSON + "\n")); err != nil {
+		t.Fatalf("saveStatusLineData() error: %v", err)
+	}
+	if got := readStatusLineData(); got == nil || got.ProjectName != "myproject" {
+		t.Errorf("readStatusLineData() after save = %+v, want project myproject", got)
+	}
+
+	if err := saveStatusLineData([]byte(`{"session_id": "trunc`)); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+	if got := readStatusLineData(); got == nil {
+		t.Error("Invalid input should not overwrite the previous data")
+	}
+}
+
+// TestPrintStatusLine tests rendering the configured statusline text
+func TestPrintStatusLine(t *testing.T) {
+	origCfg := cfg
+	defer func() { cfg = origCfg }()
+	cfg = defaultConfig()
+	cfg.StatusLine.Format = "{{.ModelName}} on {{.ProjectName}}: {{tokens .TotalTokens}} / {{cost .TotalCost}}"
+
+	var out bytes.Buffer
+	if err := printStatusLine(&out, []byte(testStatusLineJSON)); err != nil {
+		t.Fatalf("printStatusLine() error: %v", err)
+	}
+	if want := "Opus 4.5 on myproject: 15.0K / $0.5000\n"; out.String() != want {
+		t.Errorf("printStatusLine() = %q, want %q", out.String(), want)
+	}
+}
+
+// TestRunChained tests that the chained command's exit code is passed through
+func TestRunChained(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+
+	out := filepath.Join(t.TempDir(), "stdin.json")
+	if code := runChained("cat > "+out, []byte(testStatusLineJSON)); code != 0 {
+		t.Errorf("runChained() = %d, want 0", code)
+	}
+	if got, _ := os.ReadFile(out); string(got) != testStatusLineJSON {
+		t.Errorf("chained command got stdin %q, want the statusline JSON", got)
+	}
+
+	if code := runChained("exit 3", nil); code != 3 {
+		t.Errorf("runChained(exit 3) = %d, want 3", code)
+	}
+}
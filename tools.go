@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// toolTailSize is how much of the end of a transcript is read to find the
+// tool that is running
+const toolTailSize = 256 * 1024
+
+// contentBlock is an entry in a message's content
+type contentBlock struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	ToolUseID string `json:"tool_use_id"`
+}
+
+// toolTracker follows tool calls through a transcript: a tool_use in an
+// assistant message is running until a user message carries its tool_result
+type toolTracker struct {
+	running map[string]string
+	lastID  string
+}
+
+func (t *toolTracker) observe(msg *JSONLMessage) {
+	// Plain text content is a string, not a list of blocks
+	if len(msg.Message.Content) == 0 || msg.Message.Content[0] != '[' {
+		return
+	}
+	var blocks []contentBlock
+	if err := json.Unmarshal(msg.Message.Content, &blocks); err != nil {
+		return
+	}
+
+	for _, b := range blocks {
+		switch {
+		case msg.Type == "assistant" && b.Type == "tool_use" && b.ID != "":
+			if t.running == nil {
+				t.running = map[string]string{}
+			}
+			t.running[b.ID] = b.Name
+			t.lastID = b.ID
+		case msg.Type == "user" && b.Type == "tool_result":
+			delete(t.running, b.ToolUseID)
+		}
+	}
+}
+
+// current returns the name of the latest tool that is still running
+func (t *toolTracker) current() string {
+	return t.running[t.lastID]
+}
+
+// transcriptCurrentTool returns the tool running in a transcript, reading
+// only its end so it stays cheap for long sessions
+func transcriptCurrentTool(path string) string {
+	if path == "" {
+		return ""
+	}
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	offset := max(info.Size()-toolTailSize, 0)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return ""
+	}
+
+	reader := bufio.NewReaderSize(f, 64*1024)
+	if offset > 0 {
+		// Skip the line the tail starts in the middle of
+		if _, err := reader.ReadBytes('\n'); err != nil {
+			return ""
+		}
+	}
+
+	var tools toolTracker
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var msg JSONLMessage
+			if json.Unmarshal(line, &msg) == nil {
+				tools.observe(&msg)
+			}
+		}
+		if err != nil {
+			return tools.current()
+		}
+	}
+}
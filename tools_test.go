@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const toolUseLine = `{"type":"assistant","message":{"model":"claude-opus-4-5-20251101","content":[{"type":"text","text":"Let me look"},{"type":"tool_use","id":"%s","name":"%s","input":{}}]}}`
+const toolResultLine = `{"type":"user","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"%s","content":"ok"}]}}`
+
+// TestToolTracker tests finding the running tool in a transcript
+func TestToolTracker(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{
+			name:  "no tools",
+			lines: []string{`{"type":"user","message":{"role":"user","content":"hello"}}`},
+			want:  "",
+		},
+		{
+			name:  "tool running",
+			lines: []string{fmt.Sprintf(toolUseLine, "t1", "Bash")},
+			want:  "Bash",
+		},
+		{
+			name:  "tool finished",
+			lines: []string{fmt.Sprintf(toolUseLine, "t1", "Bash"), fmt.Sprintf(toolResultLine, "t1")},
+			want:  "",
+		},
+		{
+			name: "latest of several",
+			lines: []string{
+				fmt.Sprintf(toolUseLine, "t1", "Read"), fmt.Sprintf(toolResultLine, "t1"),
+				fmt.Sprintf(toolUseLine, "t2", "Edit"),
+			},
+			want: "Edit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.jsonl")
+			if err := os.WriteFile(path, []byte(strings.Join(tt.lines, "\n")+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := transcriptCurrentTool(path); got != tt.want {
+				t.Errorf("transcriptCurrentTool() = %q, want %q", got, tt.want)
+			}
+			if session := parseJSONLSession(path, ""); session != nil && session.CurrentTool != tt.want {
+				t.Errorf("parseJSONLSession().CurrentTool = %q, want %q", session.CurrentTool, tt.want)
+			}
+		})
+	}
+}
+
+// TestTranscriptCurrentToolTail tests reading only the end of a long
+// transcript
+func TestTranscriptCurrentToolTail(t *testing.T) {
+	filler := `{"type":"user","message":{"role":"user","content":"` + strings.Repeat("x", 1000) + `"}}` + "\n"
+	content := fmt.Sprintf(toolUseLine, "old", "Grep") + "\n" +
+		strings.Repeat(filler, toolTailSize/len(filler)+1) +
+		fmt.Sprintf(toolUseLine, "t1", "Bash") + "\n"
+
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := transcriptCurrentTool(path); got != "Bash" {
+		t.Errorf("transcriptCurrentTool() = %q, want %q", got, "Bash")
+	}
+	if got := transcriptCurrentTool(filepath.Join(t.TempDir(), "missing.jsonl")); got != "" {
+		t.Errorf("transcriptCurrentTool(missing) = %q, want empty", got)
+	}
+}
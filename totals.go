@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// totalsRefreshInterval limits how often the rolling totals rescan the
+// projects directory; statusline updates can arrive several times a second
+const totalsRefreshInterval = 5 * time.Second
+
+// periodTotals is the usage of the current day, week and month
+type periodTotals struct {
+	Today, Week, Month usageTotals
+}
+
+func (p *periodTotals) add(e usageEntry, today, week, month time.Time) {
+	if !e.Time.Before(today) {
+		p.Today.add(e)
+	}
+	if !e.Time.Before(week) {
+		p.Week.add(e)
+	}
+	if !e.Time.Before(month) {
+		p.Month.add(e)
+	}
+}
+
+// usageTotalsTracker keeps rolling usage totals across all sessions, overall
+// and per project. Each transcript is only read as far as it has grown since
+// the last refresh.
+type usageTotalsTracker struct {
+	files       map[string]*transcriptUsage
+	refreshedAt time.Time
+
+	periodTotals
+	Projects map[string]*periodTotals
+}
+
+// totals is the daemon's tracker, used under daemonMu
+var totals = &usageTotalsTracker{}
+
+// refresh updates the totals unless they were refreshed recently
+func (t *usageTotalsTracker) refresh(now time.Time) {
+	if now.Sub(t.refreshedAt) < totalsRefreshInterval {
+		return
+	}
+	t.refreshedAt = now
+
+	today, week, month := periodStarts(now)
+	since := week
+	if month.Before(since) {
+		since = month
+	}
+
+	files, err := listTranscripts()
+	if err != nil {
+		slog.Debug("Cannot compute usage totals", "err", err)
+		return
+	}
+
+	if t.files == nil {
+		t.files = map[string]*transcriptUsage{}
+	}
+	current := map[string]bool{}
+	for _, f := range files {
+		if f.modTime.Before(since) {
+			continue
+		}
+		current[f.path] = true
+
+		usage := t.files[f.path]
+		// A transcript that shrank was rewritten, so read it again
+		if usage == nil || f.size < usage.offset {
+			usage = &transcriptUsage{project: f.project}
+			t.files[f.path] = usage
+		}
+		if f.size == usage.offset {
+			continue
+		}
+		if err := usage.readFrom(f.path); err != nil {
+			slog.Debug("Cannot read transcript", "path", f.path, "err", err)
+		}
+	}
+	for path := range t.files {
+		if !current[path] {
+			delete(t.files, path)
+		}
+	}
+
+	t.periodTotals = periodTotals{}
+	t.Projects = map[string]*periodTotals{}
+	seen := map[string]bool{}
+	for _, usage := range t.files {
+		for _, e := range usage.entries {
+			if e.key != "" {
+				if seen[e.key] {
+					continue
+				}
+				seen[e.key] = true
+			}
+			t.add(e, today, week, month)
+
+			project := t.Projects[e.Project]
+			if project == nil {
+				project = &periodTotals{}
+				t.Projects[e.Project] = project
+			}
+			project.add(e, today, week, month)
+		}
+	}
+	slog.Debug("Refreshed usage totals", "transcripts", len(t.files),
+		"today", t.Today.TotalTokens, "week", t.Week.TotalTokens, "month", t.Month.TotalTokens)
+}
+
+// periodStarts returns the start of the local day, ISO week and month of now
+func periodStarts(now time.Time) (today, week, month time.Time) {
+	now = now.Local()
+	today = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	// Weeks start on Monday, like the ISO weeks in reports
+	week = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	return today, week, month
+}
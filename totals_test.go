@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestPeriodStarts tests the day, week and month boundaries of the totals
+func TestPeriodStarts(t *testing.T) {
+	tests := []struct {
+		name                string
+		now                 time.Time
+		wantToday, wantWeek string
+		wantMonth           string
+	}{
+		{"midweek", time.Date(2026, 1, 7, 15, 0, 0, 0, time.Local), "2026-01-07", "2026-01-05", "2026-01-01"},
+		{"monday", time.Date(2026, 1, 5, 0, 0, 0, 0, time.Local), "2026-01-05", "2026-01-05", "2026-01-01"},
+		{"sunday", time.Date(2026, 1, 11, 23, 59, 0, 0, time.Local), "2026-01-11", "2026-01-05", "2026-01-01"},
+		{"week spanning months", time.Date(2026, 3, 1, 12, 0, 0, 0, time.Local), "2026-03-01", "2026-02-23", "2026-03-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			today, week, month := periodStarts(tt.now)
+			for _, c := range []struct {
+				field string
+				got   time.Time
+				want  string
+			}{{"today", today, tt.wantToday}, {"week", week, tt.wantWeek}, {"month", month, tt.wantMonth}} {
+				if got := c.got.Format(dateLayout); got != c.want {
+					t.Errorf("%s = %s, want %s", c.field, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+// usageLine is a transcript line for an assistant message at ts
+func usageLine(ts time.Time, id string, input, output int) string {
+	return fmt.Sprintf(`{"type":"assistant","timestamp":%q,"requestId":"r-%s","message":{"id":%q,"model":"claude-opus-4-5-20251101","usage":{"input_tokens":%d,"output_tokens":%d}}}`+"\n",
+		ts.UTC().Format(time.RFC3339), id, id, input, output)
+}
+
+// TestUsageTotalsRefresh tests the rolling totals across transcripts
+func TestUsageTotalsRefresh(t *testing.T) {
+	origClaudeDir := claudeDir
+	defer setClaudeDir(origClaudeDir)
+	setClaudeDir(t.TempDir())
+
+	now := time.Now()
+	writeTranscript(t, "-tmp-app", "a.jsonl", usageLine(now, "m1", 1000, 500)+
+		usageLine(now.AddDate(0, 0, -40), "old", 7, 7))
+	// A resumed session repeats m1, which must only be counted once
+	writeTranscript(t, "-tmp-app", "b.jsonl", usageLine(now, "m1", 1000, 500))
+
+	tracker := &usageTotalsTracker{}
+	tracker.refresh(now)
+	if tracker.Today.TotalTokens != 1500 || tracker.Month.TotalTokens != 1500 {
+		t.Fatalf("after first refresh Today = %d, Month = %d tokens, want 1500",
+			tracker.Today.TotalTokens, tracker.Month.TotalTokens)
+	}
+	if want := calculateCost("claude-opus-4-5-20251101", 1000, 500); tracker.Today.Cost != want {
+		t.Errorf("Today.Cost = %f, want %f", tracker.Today.Cost, want)
+	}
+
+	// Appended lines are picked up once the refresh interval passed, and a
+	// line still being written is left for later
+	path := filepath.Join(projectsDir, "-tmp-app", "a.jsonl")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(usageLine(now, "m2", 200, 100))
+	f.WriteString(`{"type":"assistant","timestamp":`)
+	f.Close()
+
+	tracker.refresh(now.Add(time.Second))
+	if tracker.Today.TotalTokens != 1500 {
+		t.Errorf("refresh within the interval changed Today to %d tokens", tracker.Today.TotalTokens)
+	}
+
+	tracker.refresh(now.Add(totalsRefreshInterval))
+	if tracker.Today.TotalTokens != 1800 || tracker.Week.TotalTokens != 1800 {
+		t.Errorf("after append Today = %d, Week = %d tokens, want 1800",
+			tracker.Today.TotalTokens, tracker.Week.TotalTokens)
+	}
+
+	// A rewritten transcript is read from the start again
+	writeTranscript(t, "-tmp-app", "a.jsonl", usageLine(now, "m3", 10, 10))
+	tracker.refresh(now.Add(2 * totalsRefreshInterval))
+	if tracker.Today.TotalTokens != 1520 {
+		t.Errorf("after rewrite Today = %d tokens, want 1520", tracker.Today.TotalTokens)
+	}
+}
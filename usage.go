@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// usageEntry is the usage of one assistant message in a transcript
+type usageEntry struct {
+	Time         time.Time
+	Project      string
+	Model        string
+	InputTokens  int64
+	OutputTokens int64
+	Cost         float64
+	// key identifies the API request, so a message copied into another
+	// transcript when a session is resumed is only counted once
+	key string
+}
+
+// scanTranscript reads every assistant message with usage from a transcript.
+// dirProject names the project until the transcript mentions its cwd.
+func scanTranscript(path, dirProject string) ([]usageEntry, error) {
+	t := transcriptUsage{project: dirProject}
+	err := t.readFrom(path)
+	return t.entries, err
+}
+
+// transcriptUsage is the usage read so far from a transcript. Transcripts
+// only grow, so reading can continue where it stopped.
+type transcriptUsage struct {
+	// offset is where the next unread line starts
+	offset  int64
+	project string
+	cwdSeen bool
+	entries []usageEntry
+}
+
+// readFrom reads the lines appended since the last read. A line still being
+// written is left for the next read.
+func (t *transcriptUsage) readFrom(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		t.offset += int64(len(line))
+		t.parseLine(line)
+	}
+}
+
+func (t *transcriptUsage) parseLine(line []byte) {
+	var msg JSONLMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+	if msg.Cwd != "" && !t.cwdSeen {
+		t.project = filepath.Base(msg.Cwd)
+		t.cwdSeen = true
+	}
+
+	usage := msg.Message.Usage
+	if msg.Type != "assistant" || msg.Message.Model == "" || usage.InputTokens+usage.OutputTokens == 0 {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339, msg.Timestamp)
+	if err != nil {
+		return
+	}
+
+	entry := usageEntry{
+		Time:         ts,
+		Project:      t.project,
+		Model:        msg.Message.Model,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		Cost:         calculateCost(msg.Message.Model, usage.InputTokens, usage.OutputTokens),
+	}
+	if msg.Message.ID != "" {
+		entry.key = msg.Message.ID + ":" + msg.RequestID
+	}
+	t.entries = append(t.entries, entry)
+}
+
+// transcriptFile is a transcript under ~/.claude/projects
+type transcriptFile struct {
+	path    string
+	project string
+	size    int64
+	modTime time.Time
+}
+
+// listTranscripts returns every transcript under the projects directory
+func listTranscripts() ([]transcriptFile, error) {
+	var files []transcriptFile
+	err := filepath.WalkDir(projectsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == projectsDir {
+				return err
+			}
+			return nil // Skip unreadable entries
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".jsonl") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		relPath, _ := filepath.Rel(projectsDir, path)
+		dir := strings.SplitN(relPath, string(filepath.Separator), 2)[0]
+		files = append(files, transcriptFile{
+			path:    path,
+			project: filepath.Base(decodeProjectDir(dir)),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// scanUsage returns the usage of all transcripts between since and until
+// (exclusive), counting each API request once
+func scanUsage(since, until time.Time) ([]usageEntry, error) {
+	files, err := listTranscripts()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []usageEntry
+	seen := map[string]bool{}
+	for _, f := range files {
+		// A transcript last written before the range has nothing in it
+		if f.modTime.Before(since) {
+			continue
+		}
+		fileEntries, err := scanTranscript(f.path, f.project)
+		if err != nil {
+			continue
+		}
+		for _, e := range fileEntries {
+			if e.Time.Before(since) || !e.Time.Before(until) {
+				continue
+			}
+			if e.key != "" {
+				if seen[e.key] {
+					continue
+				}
+				seen[e.key] = true
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
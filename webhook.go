@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Webhook retry backoff: the first retry waits webhookBackoff, then the wait
+// doubles up to webhookMaxBackoff
+var (
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 30 * time.Second
+)
+
+// deadLetterFilePath is where webhook requests that could not be delivered
+// are kept
+func deadLetterFilePath() string {
+	return filepath.Join(claudeDir, "discord-presence-dead-letters.jsonl")
+}
+
+// webhookPayload is the default webhook body
+type webhookPayload struct {
+	// Event is update, or clear when the presence was hidden
+	Event       string       `json:"event"`
+	Details     string       `json:"details,omitempty"`
+	State       string       `json:"state,omitempty"`
+	SessionID   string       `json:"session_id,omitempty"`
+	Project     string       `json:"project,omitempty"`
+	ProjectPath string       `json:"project_path,omitempty"`
+	GitBranch   string       `json:"git_branch,omitempty"`
+	Model       string       `json:"model,omitempty"`
+	Models      []ModelUsage `json:"models,omitempty"`
+	TotalTokens int64        `json:"total_tokens"`
+	TotalCost   float64      `json:"total_cost"`
+	StartTime   *time.Time   `json:"start_time,omitempty"`
+	Today       usageTotals  `json:"today"`
+	Week        usageTotals  `json:"week"`
+	Month       usageTotals  `json:"month"`
+}
+
+// webhookData is what a webhook body template is rendered with: the
+// presence template data plus the event and the rendered lines
+type webhookData struct {
+	presenceData
+	Event   string
+	Details string
+	State   string
+}
+
+// deadLetter is a line of the dead-letter log
+type deadLetter struct {
+	Time  time.Time       `json:"time"`
+	Sink  string          `json:"sink"`
+	URL   string          `json:"url"`
+	Error string          `json:"error"`
+	Body  json.RawMessage `json:"body"`
+}
+
+// errPermanent marks a webhook failure that retrying will not fix
+type errPermanent struct{ err error }
+
+func (e errPermanent) Error() string { return e.err.Error() }
+func (e errPermanent) Unwrap() error { return e.err }
+
+// webhookSink posts presence changes to an HTTP endpoint. A body is only
+// sent when it differs from the last one delivered.
+type webhookSink struct {
+	name   string
+	c      WebhookSinkConfig
+	tmpl   *template.Template
+	client *http.Client
+
+	lastBody []byte
+	lastSent time.Time
+	// superseded reports whether a newer presence is waiting, nil outside
+	// a sink runner
+	superseded func() bool
+
+	// ctx is cancelled when the sink closes, ending any request or wait
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newWebhookSink(name string, c WebhookSinkConfig) (Sink, error) {
+	s := &webhookSink{
+		name:   name,
+		c:      c,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if c.Body != "" {
+		tmpl, err := parseTemplate("body", c.Body)
+		if err != nil {
+			return nil, err
+		}
+		s.tmpl = tmpl
+	}
+	return s, nil
+}
+
+func (s *webhookSink) Name() string {
+	return s.name
+}
+
+func (s *webhookSink) Update(p Presence) error {
+	return s.deliver("update", p)
+}
+
+func (s *webhookSink) Clear() error {
+	return s.deliver("clear", Presence{Data: presenceData{SessionData: &SessionData{}}})
+}
+
+// interrupt cuts short any request or wait, so closing does not sit out a
+// backoff
+func (s *webhookSink) interrupt() {
+	s.cancel()
+}
+
+func (s *webhookSink) setSuperseded(superseded func() bool) {
+	s.superseded = superseded
+}
+
+func (s *webhookSink) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *webhookSink) deliver(event string, p Presence) error {
+	body, err := s.body(event, p)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(body, s.lastBody) {
+		return nil
+	}
+
+	// Hold back until the rate limit allows the next request. A presence
+	// that changed again in the meantime is dropped for the newer one, so
+	// nothing piles up.
+	if wait := time.Duration(s.c.MinInterval) - time.Since(s.lastSent); wait > 0 {
+		if !s.sleep(wait) {
+			return nil
+		}
+		if s.superseded != nil && s.superseded() {
+			slog.Debug("Webhook update superseded while rate limited", "sink", s.name)
+			return nil
+		}
+	}
+
+	backoff := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = s.post(body)
+		s.lastSent = time.Now()
+		if err == nil {
+			s.lastBody = body
+			return nil
+		}
+
+		var permanent errPermanent
+		if errors.As(err, &permanent) || attempt >= s.c.Retries {
+			break
+		}
+		wait := max(backoff, retryAfter)
+		slog.Debug("Webhook failed, retrying", "sink", s.name, "in", wait, "err", err)
+		if !s.sleep(wait) {
+			break
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+
+	// Given up on: the same body is not tried again until the presence changes
+	s.lastBody = body
+	if dlErr := appendDeadLetter(deadLetter{
+		Time:  time.Now(),
+		Sink:  s.name,
+		URL:   s.c.URL,
+		Error: err.Error(),
+		Body:  body,
+	}); dlErr != nil {
+		slog.Error("Failed to write dead letter", "sink", s.name, "err", dlErr)
+	}
+	return err
+}
+
+// sleep waits for d and reports false if the sink was closed meanwhile
+func (s *webhookSink) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// body renders the request body, which must be valid JSON
+func (s *webhookSink) body(event string, p Presence) ([]byte, error) {
+	if s.tmpl == nil {
+		session := p.Data.SessionData
+		payload := webhookPayload{
+			Event:       event,
+			Details:     p.Activity.Details,
+			State:       p.Activity.State,
+			SessionID:   session.SessionID,
+			Project:     session.ProjectName,
+			ProjectPath: session.ProjectPath,
+			GitBranch:   session.GitBranch,
+			Model:       session.ModelName,
+			Models:      session.Models,
+			TotalTokens: session.TotalTokens,
+			TotalCost:   session.TotalCost,
+			StartTime:   p.Activity.StartTime,
+			Today:       p.Data.Today,
+			Week:        p.Data.Week,
+			Month:       p.Data.Month,
+		}
+		return json.Marshal(payload)
+	}
+
+	var b bytes.Buffer
+	data := webhookData{presenceData: p.Data, Event: event, Details: p.Activity.Details, State: p.Activity.State}
+	if err := s.tmpl.Execute(&b, data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(b.Bytes()) {
+		return nil, fmt.Errorf("body template did not produce valid JSON: %s", truncateText(b.String(), 200))
+	}
+	return b.Bytes(), nil
+}
+
+// post sends one request. It returns how long the server asked us to wait
+// before retrying, if it did.
+func (s *webhookSink) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, errPermanent{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cc-discord-presence/"+buildVersion())
+	for k, v := range s.c.Headers {
+		req.Header.Set(k, v)
+	}
+	if s.c.Secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+signBody(s.c.Secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		wait, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(wait) * time.Second, fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return 0, errPermanent{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+}
+
+// signBody returns the hex HMAC-SHA256 of body
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetterMu serializes dead-letter writes from the webhook goroutines
+var deadLetterMu sync.Mutex
+
+func appendDeadLetter(d deadLetter) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	f, err := os.OpenFile(deadLetterFilePath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
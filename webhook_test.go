@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tsanva/cc-discord-presence/discord"
+)
+
+// webhookReceiver records request bodies and answers with the next status
+// code in codes, then 200
+type webhookReceiver struct {
+	mu       sync.Mutex
+	codes    []int
+	bodies   []string
+	requests []*http.Request
+}
+
+func (w *webhookReceiver) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.bodies = append(w.bodies, string(body))
+	w.requests = append(w.requests, r)
+	if len(w.codes) > 0 {
+		code := w.codes[0]
+		w.codes = w.codes[1:]
+		rw.WriteHeader(code)
+	}
+}
+
+func (w *webhookReceiver) received() ([]string, []*http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.bodies...), append([]*http.Request(nil), w.requests...)
+}
+
+func newTestWebhook(t *testing.T, receiver *webhookReceiver, edit func(*WebhookSinkConfig)) *webhookSink {
+	t.Helper()
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+
+	var c WebhookSinkConfig
+	if err := json.Unmarshal([]byte(`{"url": "`+server.URL+`", "min_interval": "0s"}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(&c)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("invalid webhook config: %v", err)
+	}
+	sink, err := newWebhookSink("webhook-test", c)
+	if err != nil {
+		t.Fatalf("newWebhookSink() error: %v", err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink.(*webhookSink)
+}
+
+func webhookPresence(tokens int64) Presence {
+	return Presence{
+		Activity: discord.Activity{Details: "Working on: app", State: "Opus 4.5"},
+		Data: presenceData{
+			SessionData: &SessionData{SessionID: "s1", ProjectName: "app", ModelName: "Opus 4.5", TotalTokens: tokens},
+			Today:       usageTotals{TotalTokens: 4200},
+		},
+	}
+}
+
+// setupWebhookTest keeps dead letters in a temp dir and shortens backoff
+func setupWebhookTest(t *testing.T) {
+	t.Helper()
+	origClaudeDir, origBackoff := claudeDir, webhookBackoff
+	t.Cleanup(func() {
+		setClaudeDir(origClaudeDir)
+		webhookBackoff = origBackoff
+	})
+	setClaudeDir(t.TempDir())
+	webhookBackoff = time.Millisecond
+}
+
+// TestWebhookSinkDeliver tests the default payload, signing and deduping
+func TestWebhookSinkDeliver(t *testing.T) {
+	setupWebhookTest(t)
+	receiver := &webhookReceiver{}
+	s := newTestWebhook(t, receiver, func(c *WebhookSinkConfig) {
+		c.Secret = "s3cret"
+		c.Headers = map[string]string{"X-Team": "core"}
+	})
+
+	for _, tokens := range []int64{100, 100, 200} {
+		if err := s.Update(webhookPresence(tokens)); err != nil {
+			t.Fatalf("Update() error: %v", err)
+		}
+	}
+	if err := s.Clear(); err != nil {
+		t.Fatalf("Clear() error: %v", err)
+	}
+
+	bodies, requests := receiver.received()
+	if len(bodies) != 3 {
+		t.Fatalf("got %d requests, want 3 (unchanged presence is not resent): %v", len(bodies), bodies)
+	}
+
+	var payload webhookPayload
+	if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+		t.Fatalf("invalid payload %s: %v", bodies[0], err)
+	}
+	if payload.Event != "update" || payload.Project != "app" || payload.TotalTokens != 100 ||
+		payload.Details != "Working on: app" || payload.Today.TotalTokens != 4200 {
+		t.Errorf("payload = %+v", payload)
+	}
+	if !strings.Contains(bodies[2], `"event":"clear"`) {
+		t.Errorf("clear payload = %s, want a clear event", bodies[2])
+	}
+
+	r := requests[0]
+	if got, want := r.Header.Get("X-Signature-256"), "sha256="+signBody("s3cret", []byte(bodies[0])); got != want {
+		t.Errorf("X-Signature-256 = %q, want %q", got, want)
+	}
+	if r.Header.Get("X-Team") != "core" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", r.Header)
+	}
+}
+
+// TestWebhookSinkTemplate tests templated bodies
+func TestWebhookSinkTemplate(t *testing.T) {
+	setupWebhookTest(t)
+	receiver := &webhookReceiver{}
+	s := newTestWebhook(t, receiver, func(c *WebhookSinkConfig) {
+		c.Body = `{"text": {{json (printf "%s: %s" .Event .ProjectName)}}, "today": {{.Today.TotalTokens}}}`
+	})
+
+	if err := s.Update(webhookPresence(100)); err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	bodies, _ := receiver.received()
+	if want := `{"text": "update: app", "today": 4200}`; len(bodies) != 1 || bodies[0] != want {
+		t.Errorf("body = %v, want %s", bodies, want)
+	}
+
+	bad := newTestWebhook(t, receiver, func(c *WebhookSinkConfig) {
+		c.Body = `{"text": {{.ProjectName}}}`
+	})
+	if err := bad.Update(webhookPresence(100)); err == nil {
+		t.Error("Expected error for a body that is not JSON")
+	}
+}
+
+// TestWebhookSinkRetry tests retries, backoff and the dead-letter log
+func TestWebhookSinkRetry(t *testing.T) {
+	setupWebhookTest(t)
+
+	tests := []struct {
+		name         string
+		codes        []int
+		wantRequests int
+		wantErr      bool
+	}{
+		{"recovers after server errors", []int{500, 503}, 3, false},
+		{"gives up after retries", []int{500, 500, 500, 500}, 4, true},
+		{"client errors are not retried", []int{400}, 1, true},
+		{"rate limited", []int{429}, 2, false},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &webhookReceiver{codes: tt.codes}
+			s := newTestWebhook(t, receiver, nil)
+
+			err := s.Update(webhookPresence(int64(i)))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if bodies, _ := receiver.received(); len(bodies) != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", len(bodies), tt.wantRequests)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(deadLetterFilePath())
+	if err != nil {
+		t.Fatalf("reading dead letters: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d dead letters, want 2:\n%s", len(lines), data)
+	}
+	var letter deadLetter
+	if err := json.Unmarshal([]byte(lines[1]), &letter); err != nil {
+		t.Fatalf("invalid dead letter %s: %v", lines[1], err)
+	}
+	if letter.Sink != "webhook-test" || !strings.Contains(letter.Error, "400") || !strings.Contains(string(letter.Body), `"project":"app"`) {
+		t.Errorf("dead letter = %+v", letter)
+	}
+}
+
+// TestWebhookSinkMinInterval tests the per-sink rate limit
+func TestWebhookSinkMinInterval(t *testing.T) {
+	setupWebhookTest(t)
+	receiver := &webhookReceiver{}
+	s := newTestWebhook(t, receiver, func(c *WebhookSinkConfig) {
+		c.MinInterval = Duration(100 * time.Millisecond)
+	})
+
+	start := time.Now()
+	s.Update(webhookPresence(1))
+	s.Update(webhookPresence(2))
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("second request after %v, want at least the min interval", elapsed)
+	}
+
+	// Closing the sink ends a wait early without sending
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.interrupt()
+	}()
+	s.c.MinInterval = Duration(time.Hour)
+	if err := s.Update(webhookPresence(3)); err != nil {
+		t.Errorf("interrupted Update() error: %v", err)
+	}
+	if bodies, _ := receiver.received(); len(bodies) != 2 {
+		t.Errorf("got %d requests, want 2", len(bodies))
+	}
+}
+
+// TestWebhookSinkMinIntervalSupersede tests that a presence held back by the
+// rate limit is dropped when a newer one arrives during the wait
+func TestWebhookSinkMinIntervalSupersede(t *testing.T) {
+	setupWebhookTest(t)
+	receiver := &webhookReceiver{}
+	s := newTestWebhook(t, receiver, func(c *WebhookSinkConfig) {
+		c.MinInterval = Duration(200 * time.Millisecond)
+	})
+	r := newSinkRunner(s)
+	defer r.close()
+
+	waitFor := func(n int) []string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			bodies, _ := receiver.received()
+			if len(bodies) >= n {
+				return bodies
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d requests, timed out waiting for %d", len(bodies), n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	r.submit(sinkOp{presence: webhookPresence(1)})
+	waitFor(1)
+	r.submit(sinkOp{presence: webhookPresence(2)})
+	// Let the runner start waiting out the rate limit with the second one
+	for r.hasPending() {
+		time.Sleep(time.Millisecond)
+	}
+	r.submit(sinkOp{presence: webhookPresence(3)})
+
+	bodies := waitFor(2)
+	time.Sleep(300 * time.Millisecond)
+	if bodies, _ = receiver.received(); len(bodies) != 2 {
+		t.Fatalf("got %d requests, want the first and the latest", len(bodies))
+	}
+	if !strings.Contains(bodies[1], `"total_tokens":3`) {
+		t.Errorf("second request = %s, want the latest presence", bodies[1])
+	}
+}